@@ -0,0 +1,151 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+// Package cloudinit builds cloud-init configuration that libretto providers
+// hand to new VMs, either as native user data or through a NoCloud seed.
+package cloudinit
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Header is the first line of every cloud-config document. cloud-init
+// ignores user data in this format without it.
+const Header = "#cloud-config\n"
+
+// DefaultUser is the name of the user that the image's cloud-init
+// configuration creates by default. cloud-init skips that user when a users
+// list is given, unless it is part of the list.
+const DefaultUser = "default"
+
+// ErrUserDataConflict is returned when a VM is given both raw user data and a
+// cloud config.
+var ErrUserDataConflict = errors.New("Only one of user data and cloud config may be set")
+
+// Config is a cloud-config document. Use the Add methods to build it up and
+// Render to get the user data.
+type Config struct {
+	Hostname          string   `yaml:"hostname,omitempty"`
+	Users             []User   `yaml:"users,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+	PackageUpdate     bool     `yaml:"package_update,omitempty"`
+	Packages          []string `yaml:"packages,omitempty"`
+	WriteFiles        []File   `yaml:"write_files,omitempty"`
+	RunCmd            []string `yaml:"runcmd,omitempty"`
+}
+
+// User is a user account created on first boot.
+type User struct {
+	Name              string   `yaml:"name"`
+	Gecos             string   `yaml:"gecos,omitempty"`
+	Groups            string   `yaml:"groups,omitempty"`
+	Shell             string   `yaml:"shell,omitempty"`
+	Sudo              string   `yaml:"sudo,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+// File is a file written to the guest on first boot.
+type File struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Owner       string `yaml:"owner,omitempty"`
+	Permissions string `yaml:"permissions,omitempty"`
+	Encoding    string `yaml:"encoding,omitempty"`
+}
+
+// MarshalYAML renders the default user as the bare "default" entry
+// cloud-init expects.
+func (u User) MarshalYAML() (interface{}, error) {
+	if u.Name == DefaultUser {
+		return DefaultUser, nil
+	}
+	type plain User
+	return plain(u), nil
+}
+
+// AddUser adds a user account.
+func (c *Config) AddUser(u User) *Config {
+	c.Users = append(c.Users, u)
+	return c
+}
+
+// AddSSHKeys authorizes the public keys for the default user.
+func (c *Config) AddSSHKeys(keys ...string) *Config {
+	c.SSHAuthorizedKeys = append(c.SSHAuthorizedKeys, keys...)
+	return c
+}
+
+// AddPackages adds packages to install on first boot.
+func (c *Config) AddPackages(pkgs ...string) *Config {
+	c.Packages = append(c.Packages, pkgs...)
+	return c
+}
+
+// AddFile adds a file to write with the given permissions.
+func (c *Config) AddFile(path, content string, perm os.FileMode) *Config {
+	c.WriteFiles = append(c.WriteFiles, File{
+		Path:        path,
+		Content:     content,
+		Permissions: fmt.Sprintf("%#o", perm.Perm()),
+	})
+	return c
+}
+
+// AddCommands adds shell commands to run at the end of the first boot.
+func (c *Config) AddCommands(cmds ...string) *Config {
+	c.RunCmd = append(c.RunCmd, cmds...)
+	return c
+}
+
+// Render returns the cloud-config document, including its header.
+func (c *Config) Render() ([]byte, error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to render cloud config: %s", err)
+	}
+	return append([]byte(Header), b...), nil
+}
+
+// Base64 returns the rendered document base64 encoded, the way EC2 and Azure
+// expect user data.
+func (c *Config) Base64() (string, error) {
+	b, err := c.Render()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// UserData returns the user data to send for a VM that accepts either raw
+// user data or a cloud config. It returns ErrUserDataConflict if both are
+// set.
+func UserData(raw string, c *Config) (string, error) {
+	if c == nil {
+		return raw, nil
+	}
+	if raw != "" {
+		return "", ErrUserDataConflict
+	}
+	b, err := c.Render()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// MetaData returns a NoCloud meta-data document. instanceID must change
+// whenever cloud-init should run its first boot modules again.
+func MetaData(instanceID, hostname string) []byte {
+	md := struct {
+		InstanceID    string `yaml:"instance-id"`
+		LocalHostname string `yaml:"local-hostname,omitempty"`
+	}{instanceID, hostname}
+
+	// Marshalling two strings can't fail.
+	b, _ := yaml.Marshal(md)
+	return b
+}
@@ -0,0 +1,175 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package cloudinit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRender(t *testing.T) {
+	c := &Config{Hostname: "test"}
+	c.AddUser(User{Name: DefaultUser}).
+		AddUser(User{Name: "ops", Sudo: "ALL=(ALL) NOPASSWD:ALL", SSHAuthorizedKeys: []string{"ssh-rsa AAAA ops"}}).
+		AddSSHKeys("ssh-rsa BBBB root").
+		AddPackages("curl", "git").
+		AddFile("/etc/motd", "hello\n", 0644).
+		AddCommands("touch /tmp/done")
+
+	b, err := c.Render()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if !strings.HasPrefix(string(b), Header) {
+		t.Fatalf("Expected the cloud-config header, got: %s", b)
+	}
+
+	var out map[string]interface{}
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		t.Fatalf("Expected valid YAML, got: %s", err)
+	}
+	if out["hostname"] != "test" {
+		t.Fatalf("Expected hostname test, got: %v", out["hostname"])
+	}
+	users := out["users"].([]interface{})
+	if len(users) != 2 || users[0] != DefaultUser {
+		t.Fatalf("Expected the default user first, got: %v", users)
+	}
+	if u := users[1].(map[interface{}]interface{}); u["name"] != "ops" {
+		t.Fatalf("Expected user ops, got: %v", u)
+	}
+	files := out["write_files"].([]interface{})
+	if f := files[0].(map[interface{}]interface{}); f["permissions"] != "0644" || f["content"] != "hello\n" {
+		t.Fatalf("Expected /etc/motd with mode 0644, got: %v", f)
+	}
+	if cmds := out["runcmd"].([]interface{}); len(cmds) != 1 {
+		t.Fatalf("Expected one command, got: %v", cmds)
+	}
+	if _, ok := out["package_update"]; ok {
+		t.Fatalf("Expected unset fields to be omitted, got: %s", b)
+	}
+}
+
+func TestBase64(t *testing.T) {
+	c := &Config{Packages: []string{"curl"}}
+	s, err := c.Base64()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("Expected valid base64, got: %s", err)
+	}
+	r, _ := c.Render()
+	if string(b) != string(r) {
+		t.Fatalf("Expected %q, got: %q", r, b)
+	}
+}
+
+func TestUserData(t *testing.T) {
+	if s, err := UserData("raw", nil); err != nil || s != "raw" {
+		t.Fatalf("Expected raw user data to be kept, got: %q, %v", s, err)
+	}
+	if _, err := UserData("raw", &Config{}); err != ErrUserDataConflict {
+		t.Fatalf("Expected ErrUserDataConflict, got: %v", err)
+	}
+	s, err := UserData("", &Config{Hostname: "x"})
+	if err != nil || !strings.HasPrefix(s, Header) {
+		t.Fatalf("Expected a rendered cloud config, got: %q, %v", s, err)
+	}
+}
+
+func TestMetaData(t *testing.T) {
+	var out map[string]string
+	if err := yaml.Unmarshal(MetaData("i-1", "host"), &out); err != nil {
+		t.Fatalf("Expected valid YAML, got: %s", err)
+	}
+	if out["instance-id"] != "i-1" || out["local-hostname"] != "host" {
+		t.Fatalf("Unexpected meta-data: %v", out)
+	}
+}
+
+func TestNetworkConfigRender(t *testing.T) {
+	n := &NetworkConfig{}
+	n.AddEthernet("eth0", Ethernet{
+		Addresses:   []string{"10.0.0.5/24"},
+		Gateway4:    "10.0.0.1",
+		Nameservers: &Nameservers{Addresses: []string{"10.0.0.2"}},
+	})
+	b, err := n.Render()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+
+	var out struct {
+		Version   int
+		Ethernets map[string]map[string]interface{}
+	}
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		t.Fatalf("Expected valid YAML, got: %s", err)
+	}
+	if out.Version != NetworkConfigVersion {
+		t.Fatalf("Expected version %d, got: %d", NetworkConfigVersion, out.Version)
+	}
+	if out.Ethernets["eth0"]["gateway4"] != "10.0.0.1" {
+		t.Fatalf("Expected the gateway of eth0, got: %v", out.Ethernets)
+	}
+	if _, ok := out.Ethernets["eth0"]["dhcp4"]; ok {
+		t.Fatalf("Expected dhcp4 to be omitted, got: %s", b)
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	s, err := NewSeed(nil, nil, "vm-1", "")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if string(s.UserData) != Header {
+		t.Fatalf("Expected an empty cloud config, got: %q", s.UserData)
+	}
+	if !strings.Contains(string(s.MetaData), "local-hostname: vm-1") {
+		t.Fatalf("Expected the instance ID as hostname, got: %s", s.MetaData)
+	}
+	if s.NetworkConfig != nil {
+		t.Fatalf("Expected no network config, got: %s", s.NetworkConfig)
+	}
+
+	s, err = NewSeed(&Config{Hostname: "web"}, &NetworkConfig{}, "vm-1", "")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if !strings.Contains(string(s.MetaData), "local-hostname: web") {
+		t.Fatalf("Expected the cloud config hostname, got: %s", s.MetaData)
+	}
+	if s.NetworkConfig == nil {
+		t.Fatalf("Expected a network config")
+	}
+}
+
+func TestSeedWriteISO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudinit")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, _ := NewSeed(&Config{Hostname: "web"}, nil, "vm-1", "")
+	path := filepath.Join(dir, "seed.iso")
+	if err := s.WriteISO(path); err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the ISO to be written, got: %s", err)
+	}
+	if !bytes.Contains(b, s.UserData) || !bytes.Contains(b, s.MetaData) {
+		t.Fatalf("Expected the ISO to contain the user-data and meta-data")
+	}
+}
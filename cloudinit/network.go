@@ -0,0 +1,68 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package cloudinit
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// NetworkConfigVersion is the version of the network configuration format
+// NetworkConfig renders.
+const NetworkConfigVersion = 2
+
+// NetworkConfig is a version 2 network configuration. It is only read from a
+// NoCloud seed; cloud providers configure the network themselves.
+type NetworkConfig struct {
+	Version   int                 `yaml:"version"`
+	Ethernets map[string]Ethernet `yaml:"ethernets"`
+}
+
+// Ethernet configures one network interface. The key in
+// NetworkConfig.Ethernets is the interface name, or an arbitrary ID when Match
+// is set.
+type Ethernet struct {
+	Match       *Match       `yaml:"match,omitempty"`
+	SetName     string       `yaml:"set-name,omitempty"`
+	DHCP4       bool         `yaml:"dhcp4,omitempty"`
+	DHCP6       bool         `yaml:"dhcp6,omitempty"`
+	Addresses   []string     `yaml:"addresses,omitempty"`
+	Gateway4    string       `yaml:"gateway4,omitempty"`
+	Gateway6    string       `yaml:"gateway6,omitempty"`
+	Nameservers *Nameservers `yaml:"nameservers,omitempty"`
+}
+
+// Match selects an interface by MAC address or name.
+type Match struct {
+	MACAddress string `yaml:"macaddress,omitempty"`
+	Name       string `yaml:"name,omitempty"`
+}
+
+// Nameservers are the DNS settings of an interface.
+type Nameservers struct {
+	Addresses []string `yaml:"addresses,omitempty"`
+	Search    []string `yaml:"search,omitempty"`
+}
+
+// AddEthernet configures the interface with the given name or ID.
+func (n *NetworkConfig) AddEthernet(id string, e Ethernet) *NetworkConfig {
+	if n.Ethernets == nil {
+		n.Ethernets = map[string]Ethernet{}
+	}
+	n.Ethernets[id] = e
+	return n
+}
+
+// Render returns the network-config document.
+func (n *NetworkConfig) Render() ([]byte, error) {
+	c := *n
+	if c.Version == 0 {
+		c.Version = NetworkConfigVersion
+	}
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to render network config: %s", err)
+	}
+	return b, nil
+}
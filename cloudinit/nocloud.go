@@ -0,0 +1,65 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package cloudinit
+
+import (
+	"github.com/apcera/libretto/iso9660"
+)
+
+// SeedLabel is the volume label cloud-init's NoCloud datasource looks for.
+const SeedLabel = "cidata"
+
+// Seed is the content of a NoCloud seed. NetworkConfig is optional; cloud-init
+// falls back to DHCP on the first interface without it.
+type Seed struct {
+	UserData      []byte
+	MetaData      []byte
+	NetworkConfig []byte
+}
+
+// NewSeed returns the seed for an instance. Either config may be nil. The
+// hostname defaults to the one in the cloud config, then to the instance ID.
+func NewSeed(c *Config, n *NetworkConfig, instanceID, hostname string) (*Seed, error) {
+	s := &Seed{UserData: []byte(Header)}
+	if c != nil {
+		b, err := c.Render()
+		if err != nil {
+			return nil, err
+		}
+		s.UserData = b
+		if hostname == "" {
+			hostname = c.Hostname
+		}
+	}
+	if hostname == "" {
+		hostname = instanceID
+	}
+	s.MetaData = MetaData(instanceID, hostname)
+
+	if n != nil {
+		b, err := n.Render()
+		if err != nil {
+			return nil, err
+		}
+		s.NetworkConfig = b
+	}
+	return s, nil
+}
+
+// WriteISO writes the seed to an ISO9660 image at path. Attached to a VM as a
+// CD-ROM, it is picked up by cloud-init on first boot.
+func (s *Seed) WriteISO(path string) error {
+	img := iso9660.New(SeedLabel)
+	if err := img.AddFile("user-data", s.UserData); err != nil {
+		return err
+	}
+	if err := img.AddFile("meta-data", s.MetaData); err != nil {
+		return err
+	}
+	if s.NetworkConfig != nil {
+		if err := img.AddFile("network-config", s.NetworkConfig); err != nil {
+			return err
+		}
+	}
+	return img.WriteFile(path)
+}
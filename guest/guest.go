@@ -0,0 +1,76 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+// Package guest runs commands and copies files inside a VM through the
+// hypervisor's guest tools instead of SSH, so it works before the VM has a
+// network or an SSH server.
+package guest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PollInterval is how often a client checks whether a guest program exited.
+var PollInterval = time.Second
+
+var (
+	// ErrInvalidCredentials is returned when no guest username is set.
+	ErrInvalidCredentials = errors.New("A guest username must be supplied")
+	// ErrToolsNotRunning is returned when the guest tools are not running in
+	// the VM, so guest operations are not available yet.
+	ErrToolsNotRunning = errors.New("Guest tools are not running")
+)
+
+// Client runs commands and copies files in a VM, like ssh.Client does over
+// the network. Commands are run with /bin/sh, so the guest must be a Unix
+// system.
+type Client interface {
+	// Run runs command in the guest and copies its output to stdout and
+	// stderr, either of which may be nil. A non-zero exit status is
+	// returned as an *ExitError.
+	Run(command string, stdout io.Writer, stderr io.Writer) error
+	// Upload copies src to the guest path dst and sets its mode.
+	Upload(src io.Reader, dst string, mode uint32) error
+	// Download copies the guest path src to dst and closes dst.
+	Download(dst io.WriteCloser, src string) error
+}
+
+// Credentials are the guest OS account guest operations run as.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Validate returns ErrInvalidCredentials if no username is set.
+func (c Credentials) Validate() error {
+	if c.Username == "" {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// ExitError is returned by Client.Run when the command exits with a non-zero
+// status.
+type ExitError struct {
+	Command string
+	Status  int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("Command %q exited with status %d", e.Command, e.Status)
+}
+
+// Quote quotes s for /bin/sh.
+func Quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Redirect returns a shell command that runs command with its output written
+// to the guest files stdout and stderr. It is used by clients whose guest
+// tools can't capture the output of a program.
+func Redirect(command, stdout, stderr string) string {
+	return fmt.Sprintf("( %s ) >%s 2>%s", command, Quote(stdout), Quote(stderr))
+}
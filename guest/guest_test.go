@@ -0,0 +1,37 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package guest
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"plain", "with space", "it's", `"$HOME" && echo; \n`} {
+		out, err := exec.Command("/bin/sh", "-c", "printf %s "+Quote(s)).Output()
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got: %s", s, err)
+		}
+		if string(out) != s {
+			t.Fatalf("Expected %q to survive quoting, got: %q", s, out)
+		}
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	cmd := Redirect("echo out; echo err >&2", "/tmp/o u t", "/tmp/e'rr")
+	want := `( echo out; echo err >&2 ) >'/tmp/o u t' 2>'/tmp/e'\''rr'`
+	if cmd != want {
+		t.Fatalf("Expected %q, got: %q", want, cmd)
+	}
+}
+
+func TestCredentialsValidate(t *testing.T) {
+	if err := (Credentials{}).Validate(); err != ErrInvalidCredentials {
+		t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+	}
+	if err := (Credentials{Username: "root"}).Validate(); err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+// Package iso9660 writes small ISO9660 images with Joliet extensions, such as
+// the seed images read by cloud-init, without any external tools.
+//
+// Only a flat root directory is supported. Every file gets an 8.3 name in the
+// primary volume descriptor and keeps its original name in the Joliet tree,
+// which is what Linux, Windows and macOS read when it is present.
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// SectorSize is the logical block size of the image.
+	SectorSize = 2048
+
+	// systemAreaSectors is the number of sectors reserved before the first
+	// volume descriptor.
+	systemAreaSectors = 16
+
+	// maxLabelLength is the longest volume label that fits the descriptor.
+	maxLabelLength = 32
+	// maxJolietNameLength is the longest Joliet file name in characters.
+	maxJolietNameLength = 64
+
+	vdPrimary       = 1
+	vdSupplementary = 2
+	vdTerminator    = 255
+
+	flagDirectory = 2
+)
+
+var (
+	// ErrInvalidLabel is returned when the volume label is empty, too long or
+	// contains characters other than A-Z, 0-9 and _ once upper cased.
+	ErrInvalidLabel = errors.New("Invalid ISO9660 volume label")
+	// ErrInvalidName is returned when a file name is empty, too long or
+	// contains a path separator.
+	ErrInvalidName = errors.New("Invalid ISO9660 file name")
+	// ErrDuplicateName is returned when two files map to the same name.
+	ErrDuplicateName = errors.New("Duplicate ISO9660 file name")
+)
+
+// Image is an ISO9660 image with a single root directory. The zero value is
+// not usable; create one with New.
+type Image struct {
+	// Label is the volume identifier, e.g. "cidata".
+	Label string
+	// ModTime is recorded as the creation time of the volume and its files.
+	ModTime time.Time
+
+	files []file
+}
+
+type file struct {
+	name    string
+	isoName string
+	content []byte
+	extent  uint32
+}
+
+// New returns an empty image with the given volume label.
+func New(label string) *Image {
+	return &Image{Label: label, ModTime: time.Now()}
+}
+
+// AddFile adds a file to the root directory of the image.
+func (img *Image) AddFile(name string, content []byte) error {
+	if name == "" || len(utf16.Encode([]rune(name))) > maxJolietNameLength || strings.ContainsAny(name, `/\`) {
+		return ErrInvalidName
+	}
+	isoName := shortName(name)
+	for _, f := range img.files {
+		if f.name == name || f.isoName == isoName {
+			return ErrDuplicateName
+		}
+	}
+	img.files = append(img.files, file{name: name, isoName: isoName, content: content})
+	return nil
+}
+
+// WriteFile writes the image to the file at path.
+func (img *Image) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := img.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteTo writes the image to w.
+func (img *Image) WriteTo(w io.Writer) (int64, error) {
+	label := strings.ToUpper(img.Label)
+	if !validLabel(label) {
+		return 0, ErrInvalidLabel
+	}
+
+	// Layout: system area, primary and Joliet descriptors, terminator, the
+	// L and M path tables of both trees, both root directories, then the
+	// file contents shared by both trees.
+	const (
+		pvdSector     = systemAreaSectors
+		svdSector     = pvdSector + 1
+		termSector    = svdSector + 1
+		pvdLPathTable = termSector + 1
+		pvdMPathTable = pvdLPathTable + 1
+		svdLPathTable = pvdMPathTable + 1
+		svdMPathTable = svdLPathTable + 1
+		pvdRoot       = svdMPathTable + 1
+		svdRoot       = pvdRoot + 1
+		firstExtent   = svdRoot + 1
+	)
+
+	files := make([]file, len(img.files))
+	copy(files, img.files)
+	next := uint32(firstExtent)
+	for i := range files {
+		files[i].extent = next
+		next += sectors(len(files[i].content))
+	}
+	total := next
+
+	t := img.ModTime.UTC()
+
+	pvdFiles := make([]file, len(files))
+	copy(pvdFiles, files)
+	sort.Sort(byISOName(pvdFiles))
+	pvdDir, err := directory(pvdRoot, pvdFiles, t, func(f file) []byte { return []byte(f.isoName) })
+	if err != nil {
+		return 0, err
+	}
+
+	svdFiles := make([]file, len(files))
+	copy(svdFiles, files)
+	sort.Sort(byJolietName(svdFiles))
+	svdDir, err := directory(svdRoot, svdFiles, t, func(f file) []byte { return ucs2(f.name) })
+	if err != nil {
+		return 0, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write(make([]byte, systemAreaSectors*SectorSize))
+	buf.Write(volumeDescriptor(vdPrimary, []byte(pad(label, maxLabelLength)), total, pvdLPathTable, pvdMPathTable,
+		dirRecord([]byte{0}, pvdRoot, SectorSize, flagDirectory, t), t, padASCII))
+	buf.Write(volumeDescriptor(vdSupplementary, ucs2Pad(img.Label, maxLabelLength), total, svdLPathTable, svdMPathTable,
+		dirRecord([]byte{0}, svdRoot, SectorSize, flagDirectory, t), t, ucs2Pad))
+	buf.Write(terminator())
+	buf.Write(pathTable(pvdRoot, binary.LittleEndian))
+	buf.Write(pathTable(pvdRoot, binary.BigEndian))
+	buf.Write(pathTable(svdRoot, binary.LittleEndian))
+	buf.Write(pathTable(svdRoot, binary.BigEndian))
+	buf.Write(pvdDir)
+	buf.Write(svdDir)
+
+	n, err := buf.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+	for _, f := range files {
+		m, err := w.Write(padSector(f.content))
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// shortName maps a file name to an ISO9660 level 1 name: at most eight
+// characters, an optional three character extension and a version number.
+func shortName(name string) string {
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	return truncate(dChars(base), 8) + "." + truncate(dChars(ext), 3) + ";1"
+}
+
+func dChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func validLabel(label string) bool {
+	if label == "" || len(label) > maxLabelLength {
+		return false
+	}
+	return dChars(label) == label
+}
+
+func sectors(n int) uint32 {
+	return uint32((n + SectorSize - 1) / SectorSize)
+}
+
+func padSector(b []byte) []byte {
+	out := make([]byte, int(sectors(len(b)))*SectorSize)
+	copy(out, b)
+	return out
+}
+
+// directory returns the sector holding a root directory with the given files.
+func directory(self uint32, files []file, t time.Time, name func(file) []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.Write(dirRecord([]byte{0}, self, SectorSize, flagDirectory, t))
+	buf.Write(dirRecord([]byte{1}, self, SectorSize, flagDirectory, t))
+	for _, f := range files {
+		buf.Write(dirRecord(name(f), f.extent, uint32(len(f.content)), 0, t))
+	}
+	if buf.Len() > SectorSize {
+		return nil, fmt.Errorf("Too many files for an ISO9660 root directory: %d", len(files))
+	}
+	return padSector(buf.Bytes()), nil
+}
+
+// dirRecord returns a directory record. Records always have an even length.
+func dirRecord(name []byte, extent, size uint32, flags byte, t time.Time) []byte {
+	n := 33 + len(name)
+	if n%2 != 0 {
+		n++
+	}
+	r := make([]byte, n)
+	r[0] = byte(n)
+	bothEndian32(r[2:], extent)
+	bothEndian32(r[10:], size)
+	r[18] = byte(t.Year() - 1900)
+	r[19] = byte(t.Month())
+	r[20] = byte(t.Day())
+	r[21] = byte(t.Hour())
+	r[22] = byte(t.Minute())
+	r[23] = byte(t.Second())
+	r[25] = flags
+	bothEndian16(r[28:], 1)
+	r[32] = byte(len(name))
+	copy(r[33:], name)
+	return r
+}
+
+// pathTable returns a sector holding the path table of a tree that only has a
+// root directory.
+func pathTable(root uint32, order binary.ByteOrder) []byte {
+	p := make([]byte, SectorSize)
+	p[0] = 1
+	order.PutUint32(p[2:], root)
+	order.PutUint16(p[6:], 1)
+	return p
+}
+
+// pathTableSize is the size of a path table written by pathTable.
+const pathTableSize = 10
+
+func volumeDescriptor(kind byte, label []byte, total, lPathTable, mPathTable uint32, root []byte, t time.Time, text func(string, int) []byte) []byte {
+	vd := make([]byte, SectorSize)
+	vd[0] = kind
+	copy(vd[1:], "CD001")
+	vd[6] = 1
+	copy(vd[8:40], text("", 32))
+	copy(vd[40:72], label)
+	bothEndian32(vd[80:], total)
+	if kind == vdSupplementary {
+		// UCS-2 level 3.
+		copy(vd[88:], "%/E")
+	}
+	bothEndian16(vd[120:], 1)
+	bothEndian16(vd[124:], 1)
+	bothEndian16(vd[128:], SectorSize)
+	bothEndian32(vd[132:], pathTableSize)
+	binary.LittleEndian.PutUint32(vd[140:], lPathTable)
+	binary.BigEndian.PutUint32(vd[148:], mPathTable)
+	copy(vd[156:190], root)
+	copy(vd[190:318], text("", 128))
+	copy(vd[318:446], text("", 128))
+	copy(vd[446:574], text("", 128))
+	copy(vd[574:702], text("LIBRETTO", 128))
+	copy(vd[702:739], text("", 37))
+	copy(vd[739:776], text("", 37))
+	copy(vd[776:813], text("", 37))
+	copy(vd[813:830], decDateTime(t))
+	copy(vd[830:847], decDateTime(t))
+	copy(vd[847:864], decDateTime(time.Time{}))
+	copy(vd[864:881], decDateTime(t))
+	vd[881] = 1
+	return vd
+}
+
+func terminator() []byte {
+	vd := make([]byte, SectorSize)
+	vd[0] = vdTerminator
+	copy(vd[1:], "CD001")
+	vd[6] = 1
+	return vd
+}
+
+// decDateTime formats t the way volume descriptors record dates. The zero
+// time means "not specified".
+func decDateTime(t time.Time) []byte {
+	if t.IsZero() {
+		return append([]byte(strings.Repeat("0", 16)), 0)
+	}
+	s := fmt.Sprintf("%04d%02d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1e7)
+	return append([]byte(s), 0)
+}
+
+func bothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func bothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func pad(s string, n int) string {
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+func padASCII(s string, n int) []byte {
+	return []byte(pad(s, n))
+}
+
+// ucs2 encodes s as big endian UCS-2, the encoding of Joliet names.
+func ucs2(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.BigEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+// ucs2Pad encodes s as UCS-2 and pads it with spaces to n bytes.
+func ucs2Pad(s string, n int) []byte {
+	b := ucs2(s)
+	if len(b) > n {
+		b = b[:n&^1]
+	}
+	for len(b)+1 < n {
+		b = append(b, 0, ' ')
+	}
+	if len(b) < n {
+		b = append(b, 0)
+	}
+	return b
+}
+
+type byISOName []file
+
+func (f byISOName) Len() int           { return len(f) }
+func (f byISOName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byISOName) Less(i, j int) bool { return f[i].isoName < f[j].isoName }
+
+type byJolietName []file
+
+func (f byJolietName) Len() int      { return len(f) }
+func (f byJolietName) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f byJolietName) Less(i, j int) bool {
+	return bytes.Compare(ucs2(f[i].name), ucs2(f[j].name)) < 0
+}
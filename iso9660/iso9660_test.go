@@ -0,0 +1,142 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// readRoot returns the files in the root directory of the tree described by
+// the volume descriptor in the given sector, decoding names with decode.
+func readRoot(t *testing.T, img []byte, sector int, decode func([]byte) string) map[string]string {
+	vd := img[sector*SectorSize : (sector+1)*SectorSize]
+	if string(vd[1:6]) != "CD001" {
+		t.Fatalf("Expected a volume descriptor in sector %d", sector)
+	}
+	root := vd[156:]
+	extent := binary.LittleEndian.Uint32(root[2:])
+	size := binary.LittleEndian.Uint32(root[10:])
+	dir := img[int(extent)*SectorSize : int(extent)*SectorSize+int(size)]
+
+	files := map[string]string{}
+	for i := 0; i < len(dir) && dir[i] != 0; i += int(dir[i]) {
+		r := dir[i : i+int(dir[i])]
+		name := r[33 : 33+int(r[32])]
+		if r[25]&flagDirectory != 0 {
+			continue
+		}
+		e := binary.LittleEndian.Uint32(r[2:])
+		n := binary.LittleEndian.Uint32(r[10:])
+		files[decode(name)] = string(img[int(e)*SectorSize : int(e)*SectorSize+int(n)])
+	}
+	return files
+}
+
+func decodeUCS2(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func TestWriteTo(t *testing.T) {
+	img := New("cidata")
+	img.ModTime = time.Date(2016, 5, 4, 3, 2, 1, 0, time.UTC)
+	files := map[string]string{
+		"user-data":      "#cloud-config\n",
+		"meta-data":      "instance-id: i-1\n",
+		"network-config": strings.Repeat("x", 3*SectorSize+1),
+	}
+	for name, content := range files {
+		if err := img.AddFile(name, []byte(content)); err != nil {
+			t.Fatalf("Expected to add %s, got: %s", name, err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := img.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	b := buf.Bytes()
+	if int(n) != len(b) || len(b)%SectorSize != 0 {
+		t.Fatalf("Expected a whole number of sectors, got %d bytes", len(b))
+	}
+
+	pvd := b[16*SectorSize:]
+	if pvd[0] != vdPrimary || strings.TrimSpace(string(pvd[40:72])) != "CIDATA" {
+		t.Fatalf("Expected a primary descriptor labelled CIDATA, got: %q", pvd[40:72])
+	}
+	if total := binary.LittleEndian.Uint32(pvd[80:]); int(total)*SectorSize != len(b) {
+		t.Fatalf("Expected volume size %d, got: %d", len(b)/SectorSize, total)
+	}
+	svd := b[17*SectorSize:]
+	if svd[0] != vdSupplementary || string(svd[88:91]) != "%/E" {
+		t.Fatalf("Expected a Joliet descriptor in sector 17")
+	}
+	if label := strings.TrimSpace(decodeUCS2(svd[40:72])); label != "cidata" {
+		t.Fatalf("Expected Joliet label cidata, got: %q", label)
+	}
+	if b[18*SectorSize] != vdTerminator {
+		t.Fatalf("Expected the descriptor set terminator in sector 18")
+	}
+
+	joliet := readRoot(t, b, 17, decodeUCS2)
+	if len(joliet) != len(files) {
+		t.Fatalf("Expected %d Joliet files, got: %v", len(files), joliet)
+	}
+	for name, content := range files {
+		if joliet[name] != content {
+			t.Fatalf("Expected %s to round trip, got %d bytes", name, len(joliet[name]))
+		}
+	}
+
+	primary := readRoot(t, b, 16, func(b []byte) string { return string(b) })
+	if primary["USER_DAT.;1"] != files["user-data"] || primary["NETWORK_.;1"] != files["network-config"] {
+		t.Fatalf("Expected 8.3 names in the primary tree, got: %v", primary)
+	}
+}
+
+func TestShortName(t *testing.T) {
+	tests := map[string]string{
+		"user-data":   "USER_DAT.;1",
+		"seed.iso":    "SEED.ISO;1",
+		"archive.tgz": "ARCHIVE.TGZ;1",
+		"a.long-ext":  "A.LON;1",
+	}
+	for in, want := range tests {
+		if got := shortName(in); got != want {
+			t.Fatalf("Expected %q for %q, got: %q", want, in, got)
+		}
+	}
+}
+
+func TestAddFileErrors(t *testing.T) {
+	img := New("cidata")
+	if err := img.AddFile("", nil); err != ErrInvalidName {
+		t.Fatalf("Expected ErrInvalidName for an empty name, got: %v", err)
+	}
+	if err := img.AddFile("a/b", nil); err != ErrInvalidName {
+		t.Fatalf("Expected ErrInvalidName for a path, got: %v", err)
+	}
+	if err := img.AddFile("user-data", nil); err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if err := img.AddFile("user_data", nil); err != ErrDuplicateName {
+		t.Fatalf("Expected ErrDuplicateName for a colliding 8.3 name, got: %v", err)
+	}
+}
+
+func TestInvalidLabel(t *testing.T) {
+	for _, label := range []string{"", "with space", strings.Repeat("a", 33)} {
+		if _, err := New(label).WriteTo(&bytes.Buffer{}); err != ErrInvalidLabel {
+			t.Fatalf("Expected ErrInvalidLabel for %q, got: %v", label, err)
+		}
+	}
+}
@@ -81,6 +81,21 @@ type Credentials struct {
 type Options struct {
 	IPs       []net.IP
 	KeepAlive int
+	// Target selects which of the VM's addresses to connect to when IPs is
+	// empty.
+	Target TargetPolicy
+}
+
+// TargetPolicy selects the VM address an SSH client connects to. The zero
+// value prefers public IPv4 addresses on any network.
+type TargetPolicy struct {
+	// PreferPrivate picks private addresses over public ones.
+	PreferPrivate bool
+	// PreferIPv6 picks IPv6 addresses over IPv4 ones.
+	PreferIPv6 bool
+	// Network only allows addresses on the named network, subnet or port
+	// group.
+	Network string
 }
 
 // SSHClient provides details for the SSH connection.
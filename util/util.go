@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"sort"
 	"time"
 
 	"github.com/apcera/libretto/ssh"
 	lvm "github.com/apcera/libretto/virtualmachine"
 )
 
+// addressPollInterval is the time WaitForAddresses sleeps between lookups.
+var addressPollInterval = 2 * time.Second
+
 // Random generates a random number in between min and max
 // If min equals max then min is returned. If max is less than min
 // then the function panics.
@@ -29,12 +33,24 @@ func Random(min, max int) int {
 // GetVMIPs returns the IPs associated with the given VM. If the IPs are present
 // in options, they will be returned. Otherwise, an API call will be made to
 // get the list of IPs. An error is returned if the API call fails or returns
-// nothing.
+// nothing. The VM is never started and the call does not wait for an address;
+// see WaitForAddresses.
+//
+// When the VM can list its addresses, the IPs are ordered by options.Target so
+// that the first one is the address to connect to. Otherwise the IPs are
+// returned in the order of vm.GetIPs, minus the ones that aren't set.
 func GetVMIPs(vm lvm.VirtualMachine, options ssh.Options) ([]net.IP, error) {
 	ips := options.IPs
 	if len(ips) == 0 {
 		var err error
-		ips, err = vm.GetIPs()
+		if lister, ok := vm.(lvm.AddressLister); ok {
+			var addrs []lvm.Address
+			addrs, err = lister.GetAddresses()
+			ips = lvm.AddressIPs(SortAddresses(addrs, options.Target))
+		} else {
+			ips, err = vm.GetIPs()
+			ips = compactIPs(ips)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("Error getting IPs for the VM: %s", err)
 		}
@@ -44,3 +60,79 @@ func GetVMIPs(vm lvm.VirtualMachine, options ssh.Options) ([]net.IP, error) {
 	}
 	return ips, nil
 }
+
+// SortAddresses returns the addresses ordered by how well they match the
+// policy, best first. Addresses outside policy.Network are left out when a
+// network is set. Link local addresses always come last since they can't be
+// dialed without a zone.
+func SortAddresses(addrs []lvm.Address, policy ssh.TargetPolicy) []lvm.Address {
+	sorted := make([]lvm.Address, 0, len(addrs))
+	for _, a := range addrs {
+		if a.IP == nil {
+			continue
+		}
+		if policy.Network != "" && a.Network != policy.Network {
+			continue
+		}
+		sorted = append(sorted, a)
+	}
+	sort.Stable(byPolicy{addrs: sorted, policy: policy})
+	return sorted
+}
+
+// byPolicy sorts addresses by preference according to a target policy.
+type byPolicy struct {
+	addrs  []lvm.Address
+	policy ssh.TargetPolicy
+}
+
+func (b byPolicy) Len() int      { return len(b.addrs) }
+func (b byPolicy) Swap(i, j int) { b.addrs[i], b.addrs[j] = b.addrs[j], b.addrs[i] }
+
+func (b byPolicy) Less(i, j int) bool {
+	x, y := b.addrs[i], b.addrs[j]
+	if xl, yl := x.IP.IsLinkLocalUnicast(), y.IP.IsLinkLocalUnicast(); xl != yl {
+		return yl
+	}
+
+	xv6, yv6 := x.Family == lvm.FamilyIPv6, y.Family == lvm.FamilyIPv6
+	if b.policy.PreferIPv6 && xv6 != yv6 {
+		return xv6
+	}
+	if xp, yp := x.IsPublic(), y.IsPublic(); xp != yp {
+		return xp != b.policy.PreferPrivate
+	}
+	if xv6 != yv6 {
+		return yv6
+	}
+	return false
+}
+
+// compactIPs drops the unset entries from a positional list of IPs.
+func compactIPs(ips []net.IP) []net.IP {
+	compacted := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip != nil {
+			compacted = append(compacted, ip)
+		}
+	}
+	return compacted
+}
+
+// WaitForAddresses polls the VM until it reports at least one address or the
+// timeout expires. It never changes the power state of the VM, so the VM must
+// already be started. lvm.ErrVMBootTimeout is returned on timeout, along with
+// the last lookup error if there was one.
+func WaitForAddresses(vm lvm.AddressLister, timeout time.Duration) ([]lvm.Address, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		addrs, err := vm.GetAddresses()
+		if err == nil && len(addrs) > 0 {
+			return addrs, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, lvm.WrapErrors(lvm.ErrVMBootTimeout, err)
+		}
+		time.Sleep(addressPollInterval)
+	}
+}
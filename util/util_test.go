@@ -2,7 +2,16 @@
 
 package util
 
-import "testing"
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apcera/libretto/ssh"
+	lvm "github.com/apcera/libretto/virtualmachine"
+	"github.com/apcera/libretto/virtualmachine/mockprovider"
+)
 
 const (
 	maxSamplingSize = 100000
@@ -101,3 +110,106 @@ func sampleRandom(min int, max int, s int) map[int]interface{} {
 
 	return m
 }
+
+func TestWaitForAddresses(t *testing.T) {
+	oldInterval := addressPollInterval
+	addressPollInterval = time.Millisecond
+	defer func() {
+		addressPollInterval = oldInterval
+	}()
+
+	calls := 0
+	vm := &mockprovider.VM{
+		MockGetAddresses: func() ([]lvm.Address, error) {
+			calls++
+			if calls < 3 {
+				return nil, nil
+			}
+			return []lvm.Address{lvm.NewAddress(net.ParseIP("10.0.0.1"), 0, "")}, nil
+		},
+	}
+
+	addrs, err := WaitForAddresses(vm, time.Second)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if len(addrs) != 1 || calls != 3 {
+		t.Fatalf("Expected one address after 3 lookups, got %d after %d", len(addrs), calls)
+	}
+}
+
+func TestWaitForAddressesTimeout(t *testing.T) {
+	oldInterval := addressPollInterval
+	addressPollInterval = time.Millisecond
+	defer func() {
+		addressPollInterval = oldInterval
+	}()
+
+	vm := &mockprovider.VM{
+		MockGetAddresses: func() ([]lvm.Address, error) {
+			return nil, errors.New("no guest tools")
+		},
+	}
+
+	_, err := WaitForAddresses(vm, 10*time.Millisecond)
+	if err == nil {
+		t.Fatalf("Expected a timeout error, got nil")
+	}
+}
+
+func testAddresses() []lvm.Address {
+	private4 := lvm.NewAddress(net.ParseIP("10.0.0.4"), 0, "")
+	private4.Network = "backend"
+	linkLocal := lvm.NewAddress(net.ParseIP("fe80::4"), 0, "")
+	public6 := lvm.NewAddress(net.ParseIP("2600:1f14::4"), 0, "")
+	public6.Network = "frontend"
+	public4 := lvm.NewAddress(net.ParseIP("52.1.2.4"), 0, "")
+	public4.Network = "frontend"
+	return []lvm.Address{private4, linkLocal, public6, public4}
+}
+
+func TestSortAddresses(t *testing.T) {
+	tests := []struct {
+		policy ssh.TargetPolicy
+		want   []string
+	}{
+		{ssh.TargetPolicy{}, []string{"52.1.2.4", "2600:1f14::4", "10.0.0.4", "fe80::4"}},
+		{ssh.TargetPolicy{PreferIPv6: true}, []string{"2600:1f14::4", "52.1.2.4", "10.0.0.4", "fe80::4"}},
+		{ssh.TargetPolicy{PreferPrivate: true}, []string{"10.0.0.4", "52.1.2.4", "2600:1f14::4", "fe80::4"}},
+		{ssh.TargetPolicy{Network: "backend"}, []string{"10.0.0.4"}},
+		{ssh.TargetPolicy{Network: "frontend", PreferIPv6: true}, []string{"2600:1f14::4", "52.1.2.4"}},
+	}
+
+	for _, test := range tests {
+		sorted := SortAddresses(testAddresses(), test.policy)
+		if len(sorted) != len(test.want) {
+			t.Fatalf("Expected %d addresses for %+v, got: %v", len(test.want), test.policy, sorted)
+		}
+		for i, a := range sorted {
+			if a.IP.String() != test.want[i] {
+				t.Fatalf("Expected %s at position %d for %+v, got: %s", test.want[i], i, test.policy, a.IP)
+			}
+		}
+	}
+}
+
+func TestGetVMIPsPolicy(t *testing.T) {
+	vm := &mockprovider.VM{
+		MockGetAddresses: func() ([]lvm.Address, error) {
+			return testAddresses(), nil
+		},
+	}
+
+	ips, err := GetVMIPs(vm, ssh.Options{Target: ssh.TargetPolicy{PreferPrivate: true}})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if ips[0].String() != "10.0.0.4" {
+		t.Fatalf("Expected the private address first, got: %v", ips)
+	}
+
+	_, err = GetVMIPs(vm, ssh.Options{Target: ssh.TargetPolicy{Network: "missing"}})
+	if err != lvm.ErrVMNoIP {
+		t.Fatalf("Expected ErrVMNoIP for an unknown network, got: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package virtualmachine
+
+import "net"
+
+const (
+	// FamilyIPv4 is the family of an IPv4 address.
+	FamilyIPv4 = "ipv4"
+	// FamilyIPv6 is the family of an IPv6 address.
+	FamilyIPv6 = "ipv6"
+
+	// ScopePublic is the scope of an address reachable from outside the
+	// provider's network.
+	ScopePublic = "public"
+	// ScopePrivate is the scope of an address only reachable from inside the
+	// provider's network.
+	ScopePrivate = "private"
+	// ScopeFloating is the scope of a public address that is NATed to a
+	// private address and can be moved between VMs, such as an OpenStack
+	// floating IP.
+	ScopeFloating = "floating"
+
+	// NICUnknown is the NIC index used when the provider does not report
+	// which network card an address belongs to.
+	NICUnknown = -1
+)
+
+// privateNets are the address ranges that are not routable on the internet.
+var privateNets = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"fc00::/7",
+	"fe80::/10",
+	"::1/128",
+)
+
+// Address represents a single IP address known for a VM.
+type Address struct {
+	// IP is the address itself.
+	IP net.IP
+	// Family is either FamilyIPv4 or FamilyIPv6.
+	Family string
+	// NIC is the index of the network card the address is bound to, or
+	// NICUnknown.
+	NIC int
+	// Scope is one of ScopePublic, ScopePrivate or ScopeFloating.
+	Scope string
+	// Interface is the provider's identifier for the network card, such as
+	// an EC2 ENI ID. Empty if unknown.
+	Interface string
+	// MAC is the hardware address of the network card. Empty if unknown.
+	MAC string
+	// Network is the name of the network, subnet or port group the address
+	// belongs to. Empty if unknown.
+	Network string
+}
+
+// IsPublic returns true if the address is reachable from outside the
+// provider's network.
+func (a Address) IsPublic() bool {
+	return a.Scope == ScopePublic || a.Scope == ScopeFloating
+}
+
+// AddressLister is implemented by VMs that can report the addresses they
+// currently have without changing their power state or waiting for an
+// address to show up.
+type AddressLister interface {
+	GetAddresses() ([]Address, error)
+}
+
+// NewAddress returns an Address for ip on the given NIC. The family is taken
+// from the IP. If scope is empty it is derived from the IP range.
+func NewAddress(ip net.IP, nic int, scope string) Address {
+	if scope == "" {
+		scope = ScopePublic
+		if IsPrivateIP(ip) {
+			scope = ScopePrivate
+		}
+	}
+	return Address{
+		IP:     ip,
+		Family: IPFamily(ip),
+		NIC:    nic,
+		Scope:  scope,
+	}
+}
+
+// IPFamily returns FamilyIPv4 or FamilyIPv6 for the given IP, or an empty
+// string if the IP is invalid.
+func IPFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return FamilyIPv4
+	}
+	if len(ip) == net.IPv6len {
+		return FamilyIPv6
+	}
+	return ""
+}
+
+// IsPrivateIP returns true if the IP belongs to a private, shared, loopback
+// or link local range.
+func IsPrivateIP(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddressIPs returns the IPs of the given addresses, in the same order.
+func AddressIPs(addrs []Address) []net.IP {
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, a.IP)
+	}
+	return ips
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
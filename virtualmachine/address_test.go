@@ -0,0 +1,69 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package virtualmachine
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewAddressClassification(t *testing.T) {
+	tests := []struct {
+		ip     string
+		family string
+		scope  string
+	}{
+		{"10.1.2.3", FamilyIPv4, ScopePrivate},
+		{"172.20.0.1", FamilyIPv4, ScopePrivate},
+		{"192.168.1.10", FamilyIPv4, ScopePrivate},
+		{"169.254.10.1", FamilyIPv4, ScopePrivate},
+		{"52.10.20.30", FamilyIPv4, ScopePublic},
+		{"fe80::1", FamilyIPv6, ScopePrivate},
+		{"fd00::10", FamilyIPv6, ScopePrivate},
+		{"2600:1f14::1", FamilyIPv6, ScopePublic},
+	}
+
+	for _, test := range tests {
+		a := NewAddress(net.ParseIP(test.ip), 0, "")
+		if a.Family != test.family {
+			t.Fatalf("Expected family %q for %s, got: %q", test.family, test.ip, a.Family)
+		}
+		if a.Scope != test.scope {
+			t.Fatalf("Expected scope %q for %s, got: %q", test.scope, test.ip, a.Scope)
+		}
+	}
+}
+
+func TestNewAddressExplicitScope(t *testing.T) {
+	a := NewAddress(net.ParseIP("10.0.0.5"), 2, ScopePublic)
+	if a.Scope != ScopePublic {
+		t.Fatalf("Expected the given scope to be kept, got: %q", a.Scope)
+	}
+	if a.NIC != 2 {
+		t.Fatalf("Expected NIC 2, got: %d", a.NIC)
+	}
+}
+
+func TestAddressIPs(t *testing.T) {
+	addrs := []Address{
+		NewAddress(net.ParseIP("10.0.0.1"), 0, ""),
+		NewAddress(net.ParseIP("52.0.0.1"), 1, ""),
+	}
+	ips := AddressIPs(addrs)
+	if len(ips) != 2 || !ips[0].Equal(addrs[0].IP) || !ips[1].Equal(addrs[1].IP) {
+		t.Fatalf("Expected the IPs in order, got: %v", ips)
+	}
+}
+
+func TestAddressIsPublic(t *testing.T) {
+	for scope, want := range map[string]bool{
+		ScopePublic:   true,
+		ScopeFloating: true,
+		ScopePrivate:  false,
+	} {
+		a := NewAddress(net.ParseIP("10.0.0.1"), 0, scope)
+		if a.IsPublic() != want {
+			t.Fatalf("Expected IsPublic() to be %v for scope %q", want, scope)
+		}
+	}
+}
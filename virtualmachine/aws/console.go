@@ -0,0 +1,107 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// consoleTailLines is how many lines of console output a ConsoleError keeps.
+const consoleTailLines = 30
+
+// ConsoleError is returned by Provision and GetSSH when the instance takes too
+// long to boot, with the end of its console output to help find out why.
+type ConsoleError struct {
+	Err     error
+	Console string
+}
+
+func (e *ConsoleError) Error() string {
+	return fmt.Sprintf("%s\nConsole output:\n%s", e.Err, e.Console)
+}
+
+// ConsoleOutput returns the serial console output of the VM. AWS buffers the
+// output and may not have the last minutes of it, unless latest is set, which
+// only Nitro instances support.
+func (vm *VM) ConsoleOutput(latest bool) (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	input := &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(vm.InstanceID),
+	}
+	if latest {
+		input.Latest = aws.Bool(true)
+	}
+	resp, err := getService(vm).GetConsoleOutput(input)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get console output: %s", err)
+	}
+
+	out, err := base64.StdEncoding.DecodeString(aws.StringValue(resp.Output))
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode console output: %s", err)
+	}
+	return string(out), nil
+}
+
+// Screenshot returns a JPEG screenshot of the VM's console.
+func (vm *VM) Screenshot() ([]byte, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return nil, ErrNoInstanceID
+	}
+
+	resp, err := getService(vm).GetConsoleScreenshot(&ec2.GetConsoleScreenshotInput{
+		InstanceId: aws.String(vm.InstanceID),
+		WakeUp:     aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get console screenshot: %s", err)
+	}
+
+	img, err := base64.StdEncoding.DecodeString(aws.StringValue(resp.ImageData))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode console screenshot: %s", err)
+	}
+	return img, nil
+}
+
+// withConsole returns err as a ConsoleError with the end of the VM's console
+// output, or err itself if the output can't be fetched.
+func (vm *VM) withConsole(err error) error {
+	out, cerr := vm.ConsoleOutput(false)
+	if cerr != nil || out == "" {
+		return err
+	}
+
+	return &ConsoleError{
+		Err:     err,
+		Console: consoleTail(out, consoleTailLines),
+	}
+}
+
+// consoleTail returns the last n lines of the console output out.
+func consoleTail(out string, n int) string {
+	lines := strings.Split(strings.TrimRight(out, "\r\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isWaiterTimeout reports whether err is returned by an SDK waiter that gave
+// up.
+func isWaiterTimeout(err error) bool {
+	awsErr, isAWS := err.(awserr.Error)
+	return isAWS && awsErr.Code() == request.WaiterResourceNotReadyErrorCode
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func TestConsoleTail(t *testing.T) {
+	tests := []struct {
+		out  string
+		n    int
+		want string
+	}{
+		{"", 3, ""},
+		{"one\ntwo\n", 3, "one\ntwo"},
+		{"one\ntwo\nthree\nfour\n", 3, "two\nthree\nfour"},
+		{"one\r\ntwo\r\nthree\r\n\r\n", 2, "two\r\nthree"},
+		{"one\ntwo\nthree", 3, "one\ntwo\nthree"},
+	}
+	for _, test := range tests {
+		if got := consoleTail(test.out, test.n); got != test.want {
+			t.Fatalf("Expected %q for the last %d lines of %q, got: %q", test.want, test.n, test.out, got)
+		}
+	}
+}
+
+func TestIsWaiterTimeout(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.New(request.WaiterResourceNotReadyErrorCode, "exceeded wait attempts", nil), true},
+		{awserr.New("InvalidInstanceID.NotFound", "no such instance", nil), false},
+		{errors.New(request.WaiterResourceNotReadyErrorCode), false},
+	}
+	for _, test := range tests {
+		if got := isWaiterTimeout(test.err); got != test.want {
+			t.Fatalf("Expected %v for %v, got: %v", test.want, test.err, got)
+		}
+	}
+}
+
+func TestConsoleError(t *testing.T) {
+	err := &ConsoleError{Err: errors.New("Failed to wait for instance to run"), Console: "login:"}
+	if want := "Failed to wait for instance to run\nConsole output:\nlogin:"; err.Error() != want {
+		t.Fatalf("Expected %q, got: %q", want, err.Error())
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Credentials selects where a VM gets its AWS credentials from. The zero value
+// checks the environment and then the shared credentials file in the home
+// directory.
+type Credentials struct {
+	// AccessKeyID, SecretAccessKey and the optional SessionToken are static
+	// credentials. They take precedence over the other sources.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Profile is a profile of the shared credentials file.
+	Profile string
+
+	// EC2Role uses the role of the EC2 instance the caller runs on.
+	EC2Role bool
+
+	// RoleARN is a role to assume with the credentials from the source
+	// above. ExternalID is passed to AWS when set, and RoleSessionName
+	// defaults to one generated by the SDK.
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+}
+
+// credentials returns the credentials to sign requests with. sess configures
+// the clients that fetch EC2 role and assumed role credentials.
+func (c Credentials) credentials(sess *session.Session) *credentials.Credentials {
+	var creds *credentials.Credentials
+	switch {
+	case c.AccessKeyID != "":
+		creds = credentials.NewStaticCredentials(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)
+	case c.Profile != "":
+		creds = credentials.NewSharedCredentials("", c.Profile)
+	case c.EC2Role:
+		creds = ec2rolecreds.NewCredentials(sess)
+	default:
+		creds = credentials.NewChainCredentials(
+			[]credentials.Provider{
+				&credentials.EnvProvider{},               // check environment
+				&credentials.SharedCredentialsProvider{}, // check home dir
+			},
+		)
+	}
+
+	if c.RoleARN == "" {
+		return creds
+	}
+	return stscreds.NewCredentials(sess.Copy(&aws.Config{Credentials: creds}), c.RoleARN,
+		func(p *stscreds.AssumeRoleProvider) {
+			if c.ExternalID != "" {
+				p.ExternalID = &c.ExternalID
+			}
+			if c.RoleSessionName != "" {
+				p.RoleSessionName = c.RoleSessionName
+			}
+		})
+}
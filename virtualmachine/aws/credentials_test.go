@@ -0,0 +1,68 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestCredentialsSources(t *testing.T) {
+	f, err := ioutil.TempFile("", "libretto-aws-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("[ci]\naws_access_key_id = PROFILEKEY\naws_secret_access_key = profilesecret\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{
+		"AWS_SHARED_CREDENTIALS_FILE": f.Name(),
+		"AWS_ACCESS_KEY_ID":           "ENVKEY",
+		"AWS_SECRET_ACCESS_KEY":       "envsecret",
+	} {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		if ok {
+			defer os.Setenv(k, old)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+
+	tests := []struct {
+		creds Credentials
+		key   string
+	}{
+		{Credentials{AccessKeyID: "STATICKEY", SecretAccessKey: "staticsecret", Profile: "ci"}, "STATICKEY"},
+		{Credentials{Profile: "ci"}, "PROFILEKEY"},
+		{Credentials{}, "ENVKEY"},
+	}
+	sess := session.New()
+	for _, test := range tests {
+		v, err := test.creds.credentials(sess).Get()
+		if err != nil {
+			t.Fatalf("Expected to get no error for %+v, got: %s", test.creds, err)
+		}
+		if v.AccessKeyID != test.key {
+			t.Fatalf("Expected access key %s for %+v, got: %s", test.key, test.creds, v.AccessKeyID)
+		}
+	}
+}
+
+func TestGetRegionServiceEndpoint(t *testing.T) {
+	vm := &VM{Region: "us-west-2", Endpoint: "http://localhost:4566"}
+	if svc := getService(vm); svc.Endpoint != vm.Endpoint {
+		t.Fatalf("Expected endpoint %s, got: %s", vm.Endpoint, svc.Endpoint)
+	}
+	svc := getRegionService(&VM{}, "eu-west-1")
+	if svc.Endpoint != "https://ec2.eu-west-1.amazonaws.com" {
+		t.Fatalf("Expected the regional endpoint, got: %s", svc.Endpoint)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// imageWaiterAttempts raises the number of times the image and snapshot
+// waiters poll AWS, 15 seconds apart, since imaging large volumes takes longer
+// than their default of 10 minutes.
+const imageWaiterAttempts = 240
+
+// provisionedIOPS are the volume types whose IOPS are set when they're
+// created.
+var provisionedIOPS = map[string]bool{
+	"io1": true,
+	"io2": true,
+	"gp3": true,
+}
+
+// ErrNoImage is returned when an AMI can't be found.
+var ErrNoImage = errors.New("Missing image")
+
+// EBSSnapshot is a snapshot of one of a VM's volumes.
+type EBSSnapshot struct {
+	SnapshotID string
+	VolumeID   string
+	// DeviceName is where the volume was attached when the snapshot was
+	// taken, such as "/dev/sda1".
+	DeviceName string
+	// VolumeType and IOPS are the type and provisioned IOPS of the volume,
+	// which RestoreSnapshot gives the new volume. The type defaults to gp2.
+	VolumeType string
+	IOPS       int
+}
+
+// CreateImage creates an AMI from the VM and returns its ID once the AMI is
+// available. Unless noReboot is set, AWS reboots the instance so the file
+// systems are consistent.
+func (vm *VM) CreateImage(name string, noReboot bool) (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	svc := getService(vm)
+	resp, err := svc.CreateImage(&ec2.CreateImageInput{
+		InstanceId: aws.String(vm.InstanceID),
+		Name:       aws.String(name),
+		NoReboot:   aws.Bool(noReboot),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to create image: %s", err)
+	}
+
+	imageID := aws.StringValue(resp.ImageId)
+	if err := waitUntilImageAvailable(svc, imageID); err != nil {
+		return imageID, err
+	}
+	return imageID, nil
+}
+
+// CopyImage copies the AMI imageID from the VM's region to region under name
+// and returns the ID of the copy once it is available. The copy is made with
+// the VM's credentials.
+func (vm *VM) CopyImage(imageID, region, name string) (string, error) {
+	svc := getRegionService(vm, region)
+	resp, err := svc.CopyImage(&ec2.CopyImageInput{
+		Name:          aws.String(name),
+		SourceImageId: aws.String(imageID),
+		SourceRegion:  aws.String(getRegion(vm.Region)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to copy image: %s", err)
+	}
+
+	copyID := aws.StringValue(resp.ImageId)
+	if err := waitUntilImageAvailable(svc, copyID); err != nil {
+		return copyID, err
+	}
+	return copyID, nil
+}
+
+// DeregisterImage deregisters the AMI imageID from the VM's region and deletes
+// the EBS snapshots that back it.
+func (vm *VM) DeregisterImage(imageID string) error {
+	svc := getService(vm)
+	resp, err := svc.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(imageID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to describe image: %s", err)
+	}
+	if len(resp.Images) < 1 {
+		return ErrNoImage
+	}
+
+	_, err = svc.DeregisterImage(&ec2.DeregisterImageInput{
+		ImageId: aws.String(imageID),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to deregister image: %s", err)
+	}
+
+	for _, m := range resp.Images[0].BlockDeviceMappings {
+		if m == nil || m.Ebs == nil || m.Ebs.SnapshotId == nil {
+			continue
+		}
+		_, err := svc.DeleteSnapshot(&ec2.DeleteSnapshotInput{
+			SnapshotId: m.Ebs.SnapshotId,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to delete snapshot %s: %s", *m.Ebs.SnapshotId, err)
+		}
+	}
+
+	return nil
+}
+
+// CreateSnapshots snapshots every EBS volume attached to the VM and returns
+// the snapshots once they are completed. The snapshots get the VM's tags.
+func (vm *VM) CreateSnapshots(description string) ([]EBSSnapshot, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return nil, ErrNoInstanceID
+	}
+
+	svc := getService(vm)
+	volumes, err := getInstanceVolumes(svc, vm.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get instance's volumes: %s", err)
+	}
+
+	snaps := make([]EBSSnapshot, 0, len(volumes))
+	ids := make([]*string, 0, len(volumes))
+	for _, v := range volumes {
+		if v == nil || v.VolumeId == nil {
+			continue
+		}
+
+		resp, err := svc.CreateSnapshot(&ec2.CreateSnapshotInput{
+			Description:       aws.String(description),
+			VolumeId:          v.VolumeId,
+			TagSpecifications: tagSpecifications(vm.Tags, ec2.ResourceTypeSnapshot),
+		})
+		if err != nil {
+			return snaps, fmt.Errorf("Failed to snapshot volume %s: %s", *v.VolumeId, err)
+		}
+
+		snap := EBSSnapshot{
+			SnapshotID: aws.StringValue(resp.SnapshotId),
+			VolumeID:   *v.VolumeId,
+			VolumeType: aws.StringValue(v.VolumeType),
+			IOPS:       int(aws.Int64Value(v.Iops)),
+		}
+		for _, a := range v.Attachments {
+			if a != nil && aws.StringValue(a.InstanceId) == vm.InstanceID {
+				snap.DeviceName = aws.StringValue(a.Device)
+			}
+		}
+		snaps = append(snaps, snap)
+		ids = append(ids, resp.SnapshotId)
+	}
+
+	if len(ids) == 0 {
+		return snaps, nil
+	}
+	err = svc.WaitUntilSnapshotCompletedWithContext(aws.BackgroundContext(),
+		&ec2.DescribeSnapshotsInput{SnapshotIds: ids},
+		request.WithWaiterMaxAttempts(imageWaiterAttempts))
+	if err != nil {
+		return snaps, fmt.Errorf("Failed to wait for snapshots to complete: %s", err)
+	}
+	return snaps, nil
+}
+
+// RestoreSnapshot creates a volume from snap, of the same type as the one the
+// snapshot was taken of, and attaches it to the VM at snap.DeviceName in place
+// of the volume attached there. It returns the ID of
+// the new volume. The replaced volume is detached but not deleted. The
+// instance must be stopped to restore its root volume.
+func (vm *VM) RestoreSnapshot(snap EBSSnapshot) (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+	if snap.DeviceName == "" {
+		return "", errors.New("Missing snapshot device name")
+	}
+
+	svc := getService(vm)
+	inst, err := describeInstance(svc, vm.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	if inst.Placement == nil || inst.Placement.AvailabilityZone == nil {
+		return "", errors.New("Missing instance availability zone")
+	}
+
+	input := restoreVolumeInput(snap)
+	input.AvailabilityZone = inst.Placement.AvailabilityZone
+	input.TagSpecifications = tagSpecifications(vm.Tags, ec2.ResourceTypeVolume)
+	resp, err := svc.CreateVolume(input)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create volume: %s", err)
+	}
+	volID := aws.StringValue(resp.VolumeId)
+	err = svc.WaitUntilVolumeAvailable(&ec2.DescribeVolumesInput{
+		VolumeIds: []*string{resp.VolumeId},
+	})
+	if err != nil {
+		return volID, fmt.Errorf("Failed to wait for volume to be available: %s", err)
+	}
+
+	for _, m := range inst.BlockDeviceMappings {
+		if m == nil || m.Ebs == nil || aws.StringValue(m.DeviceName) != snap.DeviceName {
+			continue
+		}
+		if err := detachVolume(svc, vm.InstanceID, aws.StringValue(m.Ebs.VolumeId)); err != nil {
+			return volID, err
+		}
+	}
+
+	if err := attachVolume(svc, vm.InstanceID, volID, snap.DeviceName); err != nil {
+		return volID, err
+	}
+	return volID, nil
+}
+
+// restoreVolumeInput returns the input creating a volume from snap with the
+// type and IOPS of the volume it was taken of.
+func restoreVolumeInput(snap EBSSnapshot) *ec2.CreateVolumeInput {
+	input := &ec2.CreateVolumeInput{
+		SnapshotId: aws.String(snap.SnapshotID),
+		VolumeType: aws.String(defaultVolumeType),
+	}
+	if snap.VolumeType != "" {
+		input.VolumeType = aws.String(snap.VolumeType)
+	}
+	// Other volume types report their baseline IOPS, which can't be set.
+	if snap.IOPS != 0 && provisionedIOPS[aws.StringValue(input.VolumeType)] {
+		input.Iops = aws.Int64(int64(snap.IOPS))
+	}
+	return input
+}
+
+// DeleteSnapshot deletes the EBS snapshot snapshotID from the VM's region.
+func (vm *VM) DeleteSnapshot(snapshotID string) error {
+	_, err := getService(vm).DeleteSnapshot(&ec2.DeleteSnapshotInput{
+		SnapshotId: aws.String(snapshotID),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete snapshot: %s", err)
+	}
+	return nil
+}
+
+func waitUntilImageAvailable(svc *ec2.EC2, imageID string) error {
+	err := svc.WaitUntilImageAvailableWithContext(aws.BackgroundContext(),
+		&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(imageID)}},
+		request.WithWaiterMaxAttempts(imageWaiterAttempts))
+	if err != nil {
+		return fmt.Errorf("Failed to wait for image to be available: %s", err)
+	}
+	return nil
+}
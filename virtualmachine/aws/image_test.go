@@ -0,0 +1,35 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestRestoreVolumeInput(t *testing.T) {
+	tests := []struct {
+		snap       EBSSnapshot
+		volumeType string
+		iops       int64
+	}{
+		{EBSSnapshot{SnapshotID: "snap-1"}, "gp2", 0},
+		{EBSSnapshot{SnapshotID: "snap-1", VolumeType: "gp2", IOPS: 100}, "gp2", 0},
+		{EBSSnapshot{SnapshotID: "snap-1", VolumeType: "st1"}, "st1", 0},
+		{EBSSnapshot{SnapshotID: "snap-1", VolumeType: "io1", IOPS: 4000}, "io1", 4000},
+		{EBSSnapshot{SnapshotID: "snap-1", VolumeType: "gp3", IOPS: 3000}, "gp3", 3000},
+	}
+	for _, test := range tests {
+		in := restoreVolumeInput(test.snap)
+		if aws.StringValue(in.SnapshotId) != test.snap.SnapshotID {
+			t.Fatalf("Expected snapshot %s, got: %s", test.snap.SnapshotID, aws.StringValue(in.SnapshotId))
+		}
+		if aws.StringValue(in.VolumeType) != test.volumeType {
+			t.Fatalf("Expected volume type %s for %+v, got: %s", test.volumeType, test.snap, aws.StringValue(in.VolumeType))
+		}
+		if aws.Int64Value(in.Iops) != test.iops {
+			t.Fatalf("Expected %d IOPS for %+v, got: %d", test.iops, test.snap, aws.Int64Value(in.Iops))
+		}
+	}
+}
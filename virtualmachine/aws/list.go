@@ -0,0 +1,126 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// List returns a VM for every instance in region that matches filters, using
+// the credentials from the environment or home directory. filters maps EC2
+// filter names to the values they accept, for example:
+//
+//	map[string][]string{
+//		"tag:Name":            {"libretto-vm-*"},
+//		"instance-state-name": {StateStarted, StateHalted},
+//		"vpc-id":              {"vpc-1234"},
+//	}
+//
+// Tags set with SetTag are matched with "tag:<key>". The VMs are ready to use,
+// except that their SSH credentials aren't known.
+func List(region string, filters map[string][]string) ([]*VM, error) {
+	return ListWith(&VM{Region: region}, filters)
+}
+
+// ListWith is like List, but lists the instances in the region of config with
+// its credentials and endpoint, which the returned VMs share.
+func ListWith(config *VM, filters map[string][]string) ([]*VM, error) {
+	input := &ec2.DescribeInstancesInput{Filters: ec2Filters(filters)}
+
+	var vms []*VM
+	err := getService(config).DescribeInstancesPages(input,
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, r := range page.Reservations {
+				if r == nil {
+					continue
+				}
+				for _, inst := range r.Instances {
+					if hasInstanceID(inst) {
+						vms = append(vms, instanceVM(config, inst))
+					}
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe instances: %s", err)
+	}
+
+	return vms, nil
+}
+
+// ec2Filters converts filters to EC2 filters, sorted by name.
+func ec2Filters(filters map[string][]string) []*ec2.Filter {
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var f []*ec2.Filter
+	for _, name := range names {
+		f = append(f, &ec2.Filter{
+			Name:   aws.String(name),
+			Values: aws.StringSlice(filters[name]),
+		})
+	}
+	return f
+}
+
+// instanceVM returns a VM for inst, in the region of config and with its
+// credentials and endpoint.
+func instanceVM(config *VM, inst *ec2.Instance) *VM {
+	vm := &VM{
+		Region:        config.Region,
+		Creds:         config.Creds,
+		Endpoint:      config.Endpoint,
+		InstanceID:    *inst.InstanceId,
+		AMI:           aws.StringValue(inst.ImageId),
+		InstanceType:  aws.StringValue(inst.InstanceType),
+		KeyPair:       aws.StringValue(inst.KeyName),
+		VPC:           aws.StringValue(inst.VpcId),
+		Subnet:        aws.StringValue(inst.SubnetId),
+		PrivateIP:     aws.StringValue(inst.PrivateIpAddress),
+		EBSOptimized:  aws.BoolValue(inst.EbsOptimized),
+		SpotRequestID: aws.StringValue(inst.SpotInstanceRequestId),
+	}
+
+	for _, sg := range inst.SecurityGroups {
+		if sg == nil || sg.GroupId == nil {
+			continue
+		}
+		if vm.SecurityGroup == "" {
+			vm.SecurityGroup = *sg.GroupId
+		} else {
+			vm.SecurityGroups = append(vm.SecurityGroups, *sg.GroupId)
+		}
+	}
+
+	if p := inst.Placement; p != nil {
+		vm.AvailabilityZone = aws.StringValue(p.AvailabilityZone)
+		vm.PlacementGroup = aws.StringValue(p.GroupName)
+		vm.Tenancy = aws.StringValue(p.Tenancy)
+	}
+	if h := inst.HibernationOptions; h != nil {
+		vm.Hibernation = aws.BoolValue(h.Configured)
+	}
+	if p := inst.IamInstanceProfile; p != nil {
+		vm.IAMInstanceProfile = aws.StringValue(p.Arn)
+	}
+
+	if len(inst.Tags) > 0 {
+		vm.Tags = make(map[string]string, len(inst.Tags))
+		for _, t := range inst.Tags {
+			if t != nil && t.Key != nil {
+				vm.Tags[*t.Key] = aws.StringValue(t.Value)
+			}
+		}
+		vm.Name = vm.Tags[nameTag]
+	}
+
+	return vm
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestEC2Filters(t *testing.T) {
+	if f := ec2Filters(nil); f != nil {
+		t.Fatalf("Expected no filters, got: %v", f)
+	}
+	f := ec2Filters(map[string][]string{
+		"vpc-id":   {"vpc-1"},
+		"tag:Name": {"libretto-vm-*", "web-*"},
+	})
+	if len(f) != 2 || aws.StringValue(f[0].Name) != "tag:Name" || aws.StringValue(f[1].Name) != "vpc-id" {
+		t.Fatalf("Expected the filters sorted by name, got: %v", f)
+	}
+	if got := aws.StringValueSlice(f[0].Values); !reflect.DeepEqual(got, []string{"libretto-vm-*", "web-*"}) {
+		t.Fatalf("Unexpected filter values: %v", got)
+	}
+}
+
+func TestInstanceVM(t *testing.T) {
+	config := &VM{Region: "us-west-2", Endpoint: "http://localhost:4566", Creds: Credentials{Profile: "ci"}}
+	inst := &ec2.Instance{
+		InstanceId:   aws.String("i-1"),
+		ImageId:      aws.String("ami-1"),
+		InstanceType: aws.String("t2.micro"),
+		KeyName:      aws.String("ci"),
+		VpcId:        aws.String("vpc-1"),
+		SubnetId:     aws.String("subnet-1"),
+		SecurityGroups: []*ec2.GroupIdentifier{
+			nil,
+			{GroupId: aws.String("sg-1")},
+			{GroupId: aws.String("sg-2")},
+		},
+		Placement: &ec2.Placement{
+			AvailabilityZone: aws.String("us-west-2a"),
+			Tenancy:          aws.String("default"),
+		},
+		HibernationOptions: &ec2.HibernationOptions{Configured: aws.Bool(true)},
+		IamInstanceProfile: &ec2.IamInstanceProfile{Arn: aws.String("arn:aws:iam::123456789012:instance-profile/web")},
+		Tags: []*ec2.Tag{
+			{Key: aws.String(nameTag), Value: aws.String("web-1")},
+			{Key: aws.String("team"), Value: aws.String("infra")},
+		},
+	}
+
+	vm := instanceVM(config, inst)
+	if vm.Region != config.Region || vm.Endpoint != config.Endpoint || vm.Creds != config.Creds {
+		t.Fatalf("Expected the region, endpoint and credentials of the config, got: %+v", vm)
+	}
+	if vm.InstanceID != "i-1" || vm.AMI != "ami-1" || vm.InstanceType != "t2.micro" || vm.KeyPair != "ci" ||
+		vm.VPC != "vpc-1" || vm.Subnet != "subnet-1" {
+		t.Fatalf("Unexpected instance attributes: %+v", vm)
+	}
+	if vm.SecurityGroup != "sg-1" || !reflect.DeepEqual(vm.SecurityGroups, []string{"sg-2"}) {
+		t.Fatalf("Expected the first security group first, got: %s, %v", vm.SecurityGroup, vm.SecurityGroups)
+	}
+	if vm.AvailabilityZone != "us-west-2a" || vm.Tenancy != "default" || !vm.Hibernation ||
+		vm.IAMInstanceProfile != "arn:aws:iam::123456789012:instance-profile/web" {
+		t.Fatalf("Unexpected launch options: %+v", vm)
+	}
+	if vm.Name != "web-1" || vm.Tags["team"] != "infra" {
+		t.Fatalf("Expected the name and tags of the instance, got: %s, %v", vm.Name, vm.Tags)
+	}
+}
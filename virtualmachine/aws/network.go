@@ -0,0 +1,159 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// NetworkInterface is an extra ENI attached to a VM.
+type NetworkInterface struct {
+	// ID is an existing interface to attach. When it's empty, Provision
+	// creates an interface in Subnet, which is deleted with the instance,
+	// and sets ID.
+	ID             string
+	Subnet         string
+	SecurityGroups []string
+	PrivateIP      string
+}
+
+// associateElasticIP associates the VM's Elastic IP with the primary network
+// interface of the instance, allocating a new one first if the VM asks for it.
+func associateElasticIP(svc *ec2.EC2, vm *VM) error {
+	if vm.ElasticIPAllocationID == "" {
+		if !vm.AllocateElasticIP {
+			return nil
+		}
+		resp, err := svc.AllocateAddress(&ec2.AllocateAddressInput{
+			Domain: aws.String(ec2.DomainTypeVpc),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to allocate Elastic IP: %s", err)
+		}
+		vm.ElasticIPAllocationID = aws.StringValue(resp.AllocationId)
+	}
+
+	inst, err := describeInstance(svc, vm.InstanceID)
+	if err != nil {
+		return err
+	}
+	_, err = svc.AssociateAddress(associateAddressInput(vm.ElasticIPAllocationID, inst))
+	if err != nil {
+		return fmt.Errorf("Failed to associate Elastic IP: %s", err)
+	}
+	return nil
+}
+
+// associateAddressInput returns the input associating an Elastic IP with the
+// interface at device index 0 of inst. AWS rejects associating it by instance
+// ID once the instance has more than one interface, so the instance ID is
+// only used when inst reports no interfaces.
+func associateAddressInput(allocationID string, inst *ec2.Instance) *ec2.AssociateAddressInput {
+	input := &ec2.AssociateAddressInput{
+		AllocationId: aws.String(allocationID),
+	}
+	for _, ni := range inst.NetworkInterfaces {
+		if ni == nil || ni.Attachment == nil || ni.Attachment.DeviceIndex == nil {
+			continue
+		}
+		if *ni.Attachment.DeviceIndex == 0 {
+			input.NetworkInterfaceId = ni.NetworkInterfaceId
+			return input
+		}
+	}
+	input.InstanceId = inst.InstanceId
+	return input
+}
+
+// releaseElasticIP disassociates the VM's Elastic IP from the instance and
+// releases it.
+func releaseElasticIP(svc *ec2.EC2, vm *VM) error {
+	resp, err := svc.DescribeAddresses(&ec2.DescribeAddressesInput{
+		AllocationIds: []*string{aws.String(vm.ElasticIPAllocationID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to describe Elastic IP: %s", err)
+	}
+
+	for _, a := range resp.Addresses {
+		if a == nil || a.AssociationId == nil {
+			continue
+		}
+		_, err := svc.DisassociateAddress(&ec2.DisassociateAddressInput{
+			AssociationId: a.AssociationId,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to disassociate Elastic IP: %s", err)
+		}
+	}
+
+	_, err = svc.ReleaseAddress(&ec2.ReleaseAddressInput{
+		AllocationId: aws.String(vm.ElasticIPAllocationID),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to release Elastic IP: %s", err)
+	}
+	return nil
+}
+
+// attachNetworkInterfaces attaches the VM's extra interfaces to the instance,
+// after its primary one, creating those that don't exist yet.
+func attachNetworkInterfaces(svc *ec2.EC2, vm *VM) error {
+	for i := range vm.NetworkInterfaces {
+		ni := &vm.NetworkInterfaces[i]
+
+		created := false
+		if ni.ID == "" {
+			input := &ec2.CreateNetworkInterfaceInput{
+				SubnetId: aws.String(ni.Subnet),
+			}
+			if ni.PrivateIP != "" {
+				input.PrivateIpAddress = aws.String(ni.PrivateIP)
+			}
+			for _, sg := range ni.SecurityGroups {
+				input.Groups = append(input.Groups, aws.String(sg))
+			}
+			resp, err := svc.CreateNetworkInterface(input)
+			if err != nil {
+				return fmt.Errorf("Failed to create network interface: %s", err)
+			}
+			ni.ID = aws.StringValue(resp.NetworkInterface.NetworkInterfaceId)
+			created = true
+
+			err = svc.WaitUntilNetworkInterfaceAvailable(&ec2.DescribeNetworkInterfacesInput{
+				NetworkInterfaceIds: []*string{aws.String(ni.ID)},
+			})
+			if err != nil {
+				return fmt.Errorf("Failed to wait for network interface to be available: %s", err)
+			}
+		}
+
+		resp, err := svc.AttachNetworkInterface(&ec2.AttachNetworkInterfaceInput{
+			DeviceIndex:        aws.Int64(int64(i + 1)),
+			InstanceId:         aws.String(vm.InstanceID),
+			NetworkInterfaceId: aws.String(ni.ID),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to attach network interface %s: %s", ni.ID, err)
+		}
+
+		if !created {
+			continue
+		}
+		_, err = svc.ModifyNetworkInterfaceAttribute(&ec2.ModifyNetworkInterfaceAttributeInput{
+			NetworkInterfaceId: aws.String(ni.ID),
+			Attachment: &ec2.NetworkInterfaceAttachmentChanges{
+				AttachmentId:        resp.AttachmentId,
+				DeleteOnTermination: aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to set network interface %s to be deleted with the instance: %s", ni.ID, err)
+		}
+	}
+
+	return nil
+}
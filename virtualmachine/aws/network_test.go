@@ -0,0 +1,44 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func eni(id string, index int64) *ec2.InstanceNetworkInterface {
+	return &ec2.InstanceNetworkInterface{
+		NetworkInterfaceId: aws.String(id),
+		Attachment:         &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(index)},
+	}
+}
+
+func TestAssociateAddressInput(t *testing.T) {
+	tests := []struct {
+		inst       *ec2.Instance
+		instanceID string
+		eniID      string
+	}{
+		// An Elastic IP along with extra network interfaces.
+		{&ec2.Instance{InstanceId: aws.String("i-1"), NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			eni("eni-2", 1), eni("eni-1", 0), eni("eni-3", 2),
+		}}, "", "eni-1"},
+		{&ec2.Instance{InstanceId: aws.String("i-1"), NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			eni("eni-1", 0),
+		}}, "", "eni-1"},
+		// EC2-Classic instances have no interfaces.
+		{&ec2.Instance{InstanceId: aws.String("i-1")}, "i-1", ""},
+	}
+	for i, test := range tests {
+		in := associateAddressInput("eipalloc-1", test.inst)
+		if aws.StringValue(in.AllocationId) != "eipalloc-1" {
+			t.Fatalf("Expected allocation eipalloc-1, got: %s", aws.StringValue(in.AllocationId))
+		}
+		if aws.StringValue(in.InstanceId) != test.instanceID || aws.StringValue(in.NetworkInterfaceId) != test.eniID {
+			t.Fatalf("Expected instance %q and interface %q for case %d, got: %v", test.instanceID, test.eniID, i, in)
+		}
+	}
+}
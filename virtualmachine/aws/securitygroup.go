@@ -0,0 +1,308 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// callerIPURL returns the public IP address of the caller, which the default
+// security group rule allows SSH from.
+var callerIPURL = "https://checkip.amazonaws.com"
+
+// ErrNoSecurityGroup is returned when updating the rules of a VM without a
+// security group created by libretto.
+var ErrNoSecurityGroup = errors.New("Missing managed security group")
+
+// SecurityGroupRule is an inbound rule of a security group created by
+// libretto. Traffic comes from either CIDR, which can be IPv4 or IPv6, or
+// SourceGroup.
+type SecurityGroupRule struct {
+	// Protocol is "tcp", "udp", "icmp" or "-1" for all traffic, for which
+	// the ports are left at 0.
+	Protocol string
+	FromPort int
+	ToPort   int
+
+	CIDR        string
+	SourceGroup string // security group ID
+}
+
+// validateSecurityGroupRules returns an error for a rule with both a CIDR and
+// a source group, which AWS would store as two rules.
+func validateSecurityGroupRules(rules []SecurityGroupRule) error {
+	for _, r := range rules {
+		if r.CIDR != "" && r.SourceGroup != "" {
+			return fmt.Errorf("Security group rule %+v has both a CIDR and a source group", r)
+		}
+	}
+	return nil
+}
+
+// isIPv6CIDR returns true if cidr is an IPv6 range, such as "::/0".
+func isIPv6CIDR(cidr string) bool {
+	return strings.Contains(cidr, ":")
+}
+
+// defaultSecurityGroupRules allows SSH from the caller's own IP address.
+func defaultSecurityGroupRules() ([]SecurityGroupRule, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(callerIPURL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the caller's IP address: %s", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the caller's IP address: %s", err)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(b)))
+	if ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("Invalid caller IP address: %q", b)
+	}
+
+	return []SecurityGroupRule{
+		{Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: ip.String() + "/32"},
+	}, nil
+}
+
+// createSecurityGroup creates a security group for the VM in its VPC with the
+// VM's rules, and sets ManagedSecurityGroupID.
+func createSecurityGroup(svc *ec2.EC2, vm *VM) error {
+	rules := vm.SecurityGroupRules
+	if len(rules) == 0 {
+		var err error
+		if rules, err = defaultSecurityGroupRules(); err != nil {
+			return err
+		}
+	}
+
+	vpc := vm.VPC
+	if vpc == "" && vm.Subnet != "" {
+		resp, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{
+			SubnetIds: []*string{aws.String(vm.Subnet)},
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to describe subnet: %s", err)
+		}
+		if len(resp.Subnets) < 1 {
+			return errors.New("Missing subnet")
+		}
+		vpc = aws.StringValue(resp.Subnets[0].VpcId)
+	}
+
+	input := &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(vm.Name),
+		Description: aws.String(fmt.Sprintf("Created by libretto for %s", vm.Name)),
+	}
+	if vpc != "" {
+		input.VpcId = aws.String(vpc)
+	}
+	resp, err := svc.CreateSecurityGroup(input)
+	if err != nil {
+		return fmt.Errorf("Failed to create security group: %s", err)
+	}
+	vm.ManagedSecurityGroupID = aws.StringValue(resp.GroupId)
+
+	if len(vm.Tags) > 0 {
+		_, err := svc.CreateTags(&ec2.CreateTagsInput{
+			Resources: []*string{resp.GroupId},
+			Tags:      ec2Tags(vm.Tags),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to tag security group: %s", err)
+		}
+	}
+
+	return authorizeIngress(svc, vm.ManagedSecurityGroupID, rules)
+}
+
+// UpdateSecurityGroupRules replaces the rules of the security group libretto
+// created for the VM with rules. Rules that are kept aren't revoked, so their
+// traffic isn't interrupted.
+func (vm *VM) UpdateSecurityGroupRules(rules []SecurityGroupRule) error {
+	if vm.ManagedSecurityGroupID == "" {
+		return ErrNoSecurityGroup
+	}
+	if err := validateSecurityGroupRules(rules); err != nil {
+		return err
+	}
+
+	svc := getService(vm)
+	resp, err := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(vm.ManagedSecurityGroupID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to describe security group: %s", err)
+	}
+	if len(resp.SecurityGroups) < 1 {
+		return ErrNoSecurityGroup
+	}
+
+	want := make(map[SecurityGroupRule]bool, len(rules))
+	for _, r := range rules {
+		want[r] = true
+	}
+	have := make(map[SecurityGroupRule]bool)
+	var revoke []SecurityGroupRule
+	for _, r := range securityGroupRules(resp.SecurityGroups[0].IpPermissions) {
+		have[r] = true
+		if !want[r] {
+			revoke = append(revoke, r)
+		}
+	}
+	var authorize []SecurityGroupRule
+	for _, r := range rules {
+		if !have[r] {
+			authorize = append(authorize, r)
+		}
+	}
+
+	if len(revoke) > 0 {
+		_, err := svc.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(vm.ManagedSecurityGroupID),
+			IpPermissions: ipPermissions(revoke),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to revoke security group rules: %s", err)
+		}
+	}
+	if err := authorizeIngress(svc, vm.ManagedSecurityGroupID, authorize); err != nil {
+		return err
+	}
+
+	vm.SecurityGroupRules = rules
+	return nil
+}
+
+// deleteSecurityGroup waits for the VM's instance to be terminated, so the
+// group isn't in use anymore, and deletes it.
+func deleteSecurityGroup(svc *ec2.EC2, vm *VM) error {
+	err := svc.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(vm.InstanceID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to wait for instance to terminate: %s", err)
+	}
+
+	_, err = svc.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
+		GroupId: aws.String(vm.ManagedSecurityGroupID),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete security group: %s", err)
+	}
+	vm.ManagedSecurityGroupID = ""
+	return nil
+}
+
+// removeSecurityGroup deletes the security group created by a Provision call
+// that failed. The group can't be deleted while it's in use, so an instance
+// that was launched is terminated first, and its spot request cancelled.
+func removeSecurityGroup(svc *ec2.EC2, vm *VM) error {
+	if vm.InstanceID == "" {
+		_, err := svc.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
+			GroupId: aws.String(vm.ManagedSecurityGroupID),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to delete security group: %s", err)
+		}
+		vm.ManagedSecurityGroupID = ""
+		return nil
+	}
+
+	if vm.SpotRequestID != "" {
+		if err := cancelSpotRequest(svc, vm); err != nil {
+			return err
+		}
+	}
+	_, err := svc.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: []*string{aws.String(vm.InstanceID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to terminate instance: %s", err)
+	}
+	return deleteSecurityGroup(svc, vm)
+}
+
+func authorizeIngress(svc *ec2.EC2, groupID string, rules []SecurityGroupRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	_, err := svc.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: ipPermissions(rules),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to authorize security group rules: %s", err)
+	}
+	return nil
+}
+
+func ipPermissions(rules []SecurityGroupRule) []*ec2.IpPermission {
+	perms := make([]*ec2.IpPermission, 0, len(rules))
+	for _, r := range rules {
+		p := &ec2.IpPermission{
+			IpProtocol: aws.String(r.Protocol),
+			FromPort:   aws.Int64(int64(r.FromPort)),
+			ToPort:     aws.Int64(int64(r.ToPort)),
+		}
+		if isIPv6CIDR(r.CIDR) {
+			p.Ipv6Ranges = []*ec2.Ipv6Range{{CidrIpv6: aws.String(r.CIDR)}}
+		} else if r.CIDR != "" {
+			p.IpRanges = []*ec2.IpRange{{CidrIp: aws.String(r.CIDR)}}
+		}
+		if r.SourceGroup != "" {
+			p.UserIdGroupPairs = []*ec2.UserIdGroupPair{{GroupId: aws.String(r.SourceGroup)}}
+		}
+		perms = append(perms, p)
+	}
+	return perms
+}
+
+// securityGroupRules flattens the permissions of a security group into one
+// rule per source.
+func securityGroupRules(perms []*ec2.IpPermission) []SecurityGroupRule {
+	var rules []SecurityGroupRule
+	for _, p := range perms {
+		if p == nil {
+			continue
+		}
+
+		rule := SecurityGroupRule{
+			Protocol: aws.StringValue(p.IpProtocol),
+			FromPort: int(aws.Int64Value(p.FromPort)),
+			ToPort:   int(aws.Int64Value(p.ToPort)),
+		}
+		for _, r := range p.IpRanges {
+			if r != nil && r.CidrIp != nil {
+				rule.CIDR = *r.CidrIp
+				rules = append(rules, rule)
+			}
+		}
+		for _, r := range p.Ipv6Ranges {
+			if r != nil && r.CidrIpv6 != nil {
+				rule.CIDR = *r.CidrIpv6
+				rules = append(rules, rule)
+			}
+		}
+		rule.CIDR = ""
+		for _, g := range p.UserIdGroupPairs {
+			if g != nil && g.GroupId != nil {
+				rule.SourceGroup = *g.GroupId
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
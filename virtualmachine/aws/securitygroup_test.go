@@ -0,0 +1,94 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestIPPermissions(t *testing.T) {
+	rules := []SecurityGroupRule{
+		{Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "203.0.113.10/32"},
+		{Protocol: "udp", FromPort: 8000, ToPort: 8100, SourceGroup: "sg-1"},
+		{Protocol: "tcp", FromPort: 443, ToPort: 443, CIDR: "::/0"},
+		{Protocol: "-1"},
+	}
+	perms := ipPermissions(rules)
+	if len(perms) != len(rules) {
+		t.Fatalf("Expected a permission per rule, got: %v", perms)
+	}
+	p := perms[0]
+	if aws.StringValue(p.IpProtocol) != "tcp" || aws.Int64Value(p.FromPort) != 22 || aws.Int64Value(p.ToPort) != 22 ||
+		len(p.IpRanges) != 1 || aws.StringValue(p.IpRanges[0].CidrIp) != "203.0.113.10/32" || p.UserIdGroupPairs != nil {
+		t.Fatalf("Unexpected permission for a CIDR rule: %v", p)
+	}
+	p = perms[1]
+	if p.IpRanges != nil || len(p.UserIdGroupPairs) != 1 || aws.StringValue(p.UserIdGroupPairs[0].GroupId) != "sg-1" {
+		t.Fatalf("Unexpected permission for a source group rule: %v", p)
+	}
+	p = perms[2]
+	if p.IpRanges != nil || len(p.Ipv6Ranges) != 1 || aws.StringValue(p.Ipv6Ranges[0].CidrIpv6) != "::/0" {
+		t.Fatalf("Unexpected permission for an IPv6 CIDR rule: %v", p)
+	}
+	if got := securityGroupRules(perms); !reflect.DeepEqual(got, rules[:3]) {
+		t.Fatalf("Expected the rules with a source back, got: %+v", got)
+	}
+}
+
+func TestSecurityGroupRules(t *testing.T) {
+	perms := []*ec2.IpPermission{
+		nil,
+		{
+			IpProtocol: aws.String("tcp"),
+			FromPort:   aws.Int64(443),
+			ToPort:     aws.Int64(443),
+			IpRanges: []*ec2.IpRange{
+				{CidrIp: aws.String("10.0.0.0/8")},
+				{CidrIp: aws.String("192.168.0.0/16")},
+			},
+			Ipv6Ranges:       []*ec2.Ipv6Range{{CidrIpv6: aws.String("2001:db8::/32")}},
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String("sg-2")}},
+		},
+	}
+	want := []SecurityGroupRule{
+		{Protocol: "tcp", FromPort: 443, ToPort: 443, CIDR: "10.0.0.0/8"},
+		{Protocol: "tcp", FromPort: 443, ToPort: 443, CIDR: "192.168.0.0/16"},
+		{Protocol: "tcp", FromPort: 443, ToPort: 443, CIDR: "2001:db8::/32"},
+		{Protocol: "tcp", FromPort: 443, ToPort: 443, SourceGroup: "sg-2"},
+	}
+	if got := securityGroupRules(perms); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %+v, got: %+v", want, got)
+	}
+}
+
+func TestValidateSecurityGroupRules(t *testing.T) {
+	tests := []struct {
+		rules []SecurityGroupRule
+		valid bool
+	}{
+		{nil, true},
+		{[]SecurityGroupRule{{Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "::/0"}}, true},
+		{[]SecurityGroupRule{{Protocol: "tcp", FromPort: 22, ToPort: 22, SourceGroup: "sg-1"}}, true},
+		{[]SecurityGroupRule{
+			{Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "10.0.0.0/8"},
+			{Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "10.0.0.0/8", SourceGroup: "sg-1"},
+		}, false},
+	}
+	for _, test := range tests {
+		if err := validateSecurityGroupRules(test.rules); (err == nil) != test.valid {
+			t.Fatalf("Expected valid to be %v for %+v, got: %v", test.valid, test.rules, err)
+		}
+	}
+}
+
+func TestUpdateSecurityGroupRulesInvalid(t *testing.T) {
+	vm := &VM{ManagedSecurityGroupID: "sg-1"}
+	rules := []SecurityGroupRule{{Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "10.0.0.0/8", SourceGroup: "sg-2"}}
+	if err := vm.UpdateSecurityGroupRules(rules); err == nil {
+		t.Fatalf("Expected an error for a rule with both a CIDR and a source group")
+	}
+}
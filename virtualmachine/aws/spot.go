@@ -0,0 +1,228 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// SpotInterruptionNotice is how long before an interruption AWS marks a spot
+// request for it.
+const SpotInterruptionNotice = 2 * time.Minute
+
+// ErrNoSpotRequestID is returned when watching for interruptions of a VM that
+// wasn't provisioned as a spot instance.
+var ErrNoSpotRequestID = errors.New("Missing spot request ID")
+
+// ErrInvalidWatchInterval is returned when watching for interruptions with an
+// interval that isn't positive.
+var ErrInvalidWatchInterval = errors.New("Watch interval must be positive")
+
+// ErrPersistentSpotTerminate is returned when provisioning a persistent spot
+// request that terminates its instance on interruption, which AWS rejects.
+var ErrPersistentSpotTerminate = errors.New("Persistent spot requests can't terminate on interruption")
+
+// spotCapacityCodes are the RunInstances error codes for which a spot launch
+// may fall back to on-demand.
+var spotCapacityCodes = map[string]bool{
+	"InsufficientInstanceCapacity":  true,
+	"SpotMaxPriceTooLow":            true,
+	"MaxSpotInstanceCountExceeded":  true,
+	"InsufficientSpotInstanceCount": true,
+}
+
+// spotInterruptionCodes maps the status codes of a spot request marked for
+// interruption to the action AWS is going to take.
+var spotInterruptionCodes = map[string]string{
+	"marked-for-termination":                      ec2.InstanceInterruptionBehaviorTerminate,
+	"marked-for-stop":                             ec2.InstanceInterruptionBehaviorStop,
+	"marked-for-hibernation":                      ec2.InstanceInterruptionBehaviorHibernate,
+	"instance-terminated-by-price":                ec2.InstanceInterruptionBehaviorTerminate,
+	"instance-terminated-no-capacity":             ec2.InstanceInterruptionBehaviorTerminate,
+	"instance-terminated-capacity-oversubscribed": ec2.InstanceInterruptionBehaviorTerminate,
+	"instance-stopped-by-price":                   ec2.InstanceInterruptionBehaviorStop,
+	"instance-stopped-no-capacity":                ec2.InstanceInterruptionBehaviorStop,
+	"instance-stopped-capacity-oversubscribed":    ec2.InstanceInterruptionBehaviorStop,
+}
+
+// SpotOptions requests a spot instance instead of an on-demand one.
+type SpotOptions struct {
+	// MaxPrice is the maximum hourly price in USD, such as "0.05". The
+	// on-demand price is used when it's empty.
+	MaxPrice string
+	// Persistent makes the request persistent, so AWS launches a new
+	// instance after an interruption. Requests are one-time by default.
+	Persistent bool
+	// InterruptionBehavior is "terminate", "stop" or "hibernate". It
+	// defaults to "terminate", or "stop" for persistent requests, which
+	// can't use "terminate".
+	InterruptionBehavior string
+	// FallbackOnDemand launches an on-demand instance when there is no spot
+	// capacity at MaxPrice.
+	FallbackOnDemand bool
+}
+
+// SpotInterruption is a pending interruption of a spot instance.
+type SpotInterruption struct {
+	// Action is "terminate", "stop" or "hibernate".
+	Action string
+	// Time is when AWS interrupts the instance, or interrupted it if the
+	// instance is already terminated or stopped.
+	Time time.Time
+}
+
+// validate returns an error for options AWS rejects.
+func (o *SpotOptions) validate() error {
+	if o.Persistent && o.InterruptionBehavior == ec2.InstanceInterruptionBehaviorTerminate {
+		return ErrPersistentSpotTerminate
+	}
+	return nil
+}
+
+func (o *SpotOptions) marketOptions() *ec2.InstanceMarketOptionsRequest {
+	spot := &ec2.SpotMarketOptions{
+		SpotInstanceType: aws.String(ec2.SpotInstanceTypeOneTime),
+	}
+	if o.Persistent {
+		spot.SpotInstanceType = aws.String(ec2.SpotInstanceTypePersistent)
+	}
+	if o.MaxPrice != "" {
+		spot.MaxPrice = aws.String(o.MaxPrice)
+	}
+	if o.InterruptionBehavior != "" {
+		spot.InstanceInterruptionBehavior = aws.String(o.InterruptionBehavior)
+	} else if o.Persistent {
+		spot.InstanceInterruptionBehavior = aws.String(ec2.InstanceInterruptionBehaviorStop)
+	}
+	return &ec2.InstanceMarketOptionsRequest{
+		MarketType:  aws.String(ec2.MarketTypeSpot),
+		SpotOptions: spot,
+	}
+}
+
+// runInstances launches the VM's instance, as a spot instance if vm.Spot is
+// set. It retries on-demand when spot capacity is missing and the VM allows
+// it.
+func runInstances(svc *ec2.EC2, vm *VM, input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	if vm.Spot == nil {
+		return svc.RunInstances(input)
+	}
+
+	input.InstanceMarketOptions = vm.Spot.marketOptions()
+	resp, err := svc.RunInstances(input)
+	if err == nil || !vm.Spot.FallbackOnDemand {
+		return resp, err
+	}
+	if !isSpotCapacityError(err) {
+		return resp, err
+	}
+
+	input.InstanceMarketOptions = nil
+	return svc.RunInstances(input)
+}
+
+// isSpotCapacityError returns true if err is a RunInstances error for missing
+// spot capacity.
+func isSpotCapacityError(err error) bool {
+	awsErr, isAWS := err.(awserr.Error)
+	return isAWS && spotCapacityCodes[awsErr.Code()]
+}
+
+// GetSpotInterruption returns the pending interruption of the VM's spot
+// instance, or nil if AWS hasn't marked the spot request for one.
+func (vm *VM) GetSpotInterruption() (*SpotInterruption, error) {
+	if vm.SpotRequestID == "" {
+		return nil, ErrNoSpotRequestID
+	}
+
+	svc := getService(vm)
+	resp, err := svc.DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []*string{aws.String(vm.SpotRequestID)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe spot request: %s", err)
+	}
+	if len(resp.SpotInstanceRequests) < 1 {
+		return nil, errors.New("Missing spot request")
+	}
+
+	return spotInterruption(resp.SpotInstanceRequests[0].Status), nil
+}
+
+// spotInterruption returns the interruption reported by the status of a spot
+// request, or nil. A request marked for interruption is interrupted
+// SpotInterruptionNotice after its status was updated, while the instance of
+// a terminated or stopped one was interrupted at the update.
+func spotInterruption(status *ec2.SpotInstanceStatus) *SpotInterruption {
+	if status == nil {
+		return nil
+	}
+	code := aws.StringValue(status.Code)
+	action, ok := spotInterruptionCodes[code]
+	if !ok {
+		return nil
+	}
+
+	at := time.Now()
+	if status.UpdateTime != nil {
+		at = *status.UpdateTime
+	}
+	if strings.HasPrefix(code, "marked-for-") {
+		at = at.Add(SpotInterruptionNotice)
+	}
+	return &SpotInterruption{
+		Action: action,
+		Time:   at,
+	}
+}
+
+// WatchSpotInterruption polls the VM's spot request every interval and sends
+// the interruption on the returned channel once AWS issues the two-minute
+// notice. The channel is closed after the notice or when done is closed.
+// Errors querying AWS are retried at the next interval.
+func (vm *VM) WatchSpotInterruption(interval time.Duration, done <-chan struct{}) (<-chan *SpotInterruption, error) {
+	if vm.SpotRequestID == "" {
+		return nil, ErrNoSpotRequestID
+	}
+	if interval <= 0 {
+		return nil, ErrInvalidWatchInterval
+	}
+
+	c := make(chan *SpotInterruption, 1)
+	go func() {
+		defer close(c)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if in, err := vm.GetSpotInterruption(); err == nil && in != nil {
+				c <- in
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return c, nil
+}
+
+// cancelSpotRequest cancels the VM's spot request, so a persistent request
+// doesn't launch a new instance once the current one is terminated.
+func cancelSpotRequest(svc *ec2.EC2, vm *VM) error {
+	_, err := svc.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []*string{aws.String(vm.SpotRequestID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to cancel spot request: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestSpotInterruption(t *testing.T) {
+	updated := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		code   string
+		action string
+		time   time.Time
+	}{
+		{"marked-for-termination", ec2.InstanceInterruptionBehaviorTerminate, updated.Add(SpotInterruptionNotice)},
+		{"marked-for-stop", ec2.InstanceInterruptionBehaviorStop, updated.Add(SpotInterruptionNotice)},
+		{"instance-terminated-by-price", ec2.InstanceInterruptionBehaviorTerminate, updated},
+		{"instance-stopped-no-capacity", ec2.InstanceInterruptionBehaviorStop, updated},
+		{"fulfilled", "", time.Time{}},
+	}
+	for _, test := range tests {
+		in := spotInterruption(&ec2.SpotInstanceStatus{
+			Code:       aws.String(test.code),
+			UpdateTime: aws.Time(updated),
+		})
+		if test.action == "" {
+			if in != nil {
+				t.Fatalf("Expected no interruption for %s, got: %+v", test.code, in)
+			}
+			continue
+		}
+		if in == nil || in.Action != test.action || !in.Time.Equal(test.time) {
+			t.Fatalf("Expected %s at %s for %s, got: %+v", test.action, test.time, test.code, in)
+		}
+	}
+
+	if in := spotInterruption(nil); in != nil {
+		t.Fatalf("Expected no interruption without a status, got: %+v", in)
+	}
+}
+
+func TestMarketOptions(t *testing.T) {
+	tests := []struct {
+		opts     SpotOptions
+		spotType string
+		maxPrice *string
+		behavior *string
+	}{
+		{SpotOptions{}, ec2.SpotInstanceTypeOneTime, nil, nil},
+		{SpotOptions{MaxPrice: "0.05"}, ec2.SpotInstanceTypeOneTime, aws.String("0.05"), nil},
+		{
+			SpotOptions{Persistent: true, InterruptionBehavior: ec2.InstanceInterruptionBehaviorStop},
+			ec2.SpotInstanceTypePersistent, nil, aws.String(ec2.InstanceInterruptionBehaviorStop),
+		},
+		{
+			SpotOptions{Persistent: true},
+			ec2.SpotInstanceTypePersistent, nil, aws.String(ec2.InstanceInterruptionBehaviorStop),
+		},
+		{
+			SpotOptions{InterruptionBehavior: ec2.InstanceInterruptionBehaviorHibernate},
+			ec2.SpotInstanceTypeOneTime, nil, aws.String(ec2.InstanceInterruptionBehaviorHibernate),
+		},
+	}
+	for _, test := range tests {
+		m := test.opts.marketOptions()
+		if aws.StringValue(m.MarketType) != ec2.MarketTypeSpot {
+			t.Fatalf("Expected the spot market, got: %s", aws.StringValue(m.MarketType))
+		}
+		spot := m.SpotOptions
+		if aws.StringValue(spot.SpotInstanceType) != test.spotType {
+			t.Fatalf("Expected a %s request for %+v, got: %s", test.spotType, test.opts, aws.StringValue(spot.SpotInstanceType))
+		}
+		if !equalStringPtr(spot.MaxPrice, test.maxPrice) {
+			t.Fatalf("Expected max price %v for %+v, got: %v", aws.StringValue(test.maxPrice), test.opts, aws.StringValue(spot.MaxPrice))
+		}
+		if !equalStringPtr(spot.InstanceInterruptionBehavior, test.behavior) {
+			t.Fatalf("Expected interruption behavior %v for %+v, got: %v", aws.StringValue(test.behavior), test.opts, aws.StringValue(spot.InstanceInterruptionBehavior))
+		}
+	}
+}
+
+func TestSpotOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts SpotOptions
+		err  error
+	}{
+		{SpotOptions{}, nil},
+		{SpotOptions{Persistent: true}, nil},
+		{SpotOptions{InterruptionBehavior: ec2.InstanceInterruptionBehaviorTerminate}, nil},
+		{SpotOptions{Persistent: true, InterruptionBehavior: ec2.InstanceInterruptionBehaviorHibernate}, nil},
+		{SpotOptions{Persistent: true, InterruptionBehavior: ec2.InstanceInterruptionBehaviorTerminate}, ErrPersistentSpotTerminate},
+	}
+	for _, test := range tests {
+		if err := test.opts.validate(); err != test.err {
+			t.Fatalf("Expected %v for %+v, got: %v", test.err, test.opts, err)
+		}
+	}
+}
+
+func TestWatchSpotInterruptionInterval(t *testing.T) {
+	vm := &VM{SpotRequestID: "sir-1"}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := vm.WatchSpotInterruption(interval, nil); err != ErrInvalidWatchInterval {
+			t.Fatalf("Expected ErrInvalidWatchInterval for %s, got: %v", interval, err)
+		}
+	}
+	if _, err := (&VM{}).WatchSpotInterruption(time.Second, nil); err != ErrNoSpotRequestID {
+		t.Fatalf("Expected ErrNoSpotRequestID, got: %v", err)
+	}
+}
+
+func TestIsSpotCapacityError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.New("InsufficientInstanceCapacity", "no capacity", nil), true},
+		{awserr.New("SpotMaxPriceTooLow", "price too low", nil), true},
+		{awserr.New("MaxSpotInstanceCountExceeded", "limit", nil), true},
+		{awserr.New("InsufficientSpotInstanceCount", "no capacity", nil), true},
+		{awserr.New("InvalidAMIID.NotFound", "no such ami", nil), false},
+		{errors.New("InsufficientInstanceCapacity"), false},
+	}
+	for _, test := range tests {
+		if got := isSpotCapacityError(test.err); got != test.want {
+			t.Fatalf("Expected %v for %v, got: %v", test.want, test.err, got)
+		}
+	}
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
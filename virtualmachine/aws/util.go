@@ -3,21 +3,33 @@
 package aws
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"sort"
+	"strings"
 
+	"github.com/apcera/libretto/virtualmachine"
 	"github.com/apcera/util/uuid"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
-// ValidCredentials sends a dummy request to AWS to check if credentials are
-// valid. An error is returned if credentials are missing or region is missing.
+// ValidCredentials sends a dummy request to AWS to check if the credentials
+// from the environment or home directory are valid. An error is returned if
+// credentials are missing or region is missing.
 func ValidCredentials(region string) error {
-	_, err := getService(region).DescribeInstances(nil)
+	return (&VM{Region: region}).ValidCredentials()
+}
+
+// ValidCredentials sends a dummy request to AWS to check if the credentials
+// the VM is configured with are valid. An error is returned if credentials are
+// missing, rejected or can't be fetched, or if region is missing.
+func (vm *VM) ValidCredentials() error {
+	_, err := getService(vm).DescribeInstances(nil)
 	awsErr, isAWS := err.(awserr.Error)
 	if !isAWS {
 		return err
@@ -28,12 +40,91 @@ func ValidCredentials(region string) error {
 		return ErrNoCreds
 	case noRegionCode:
 		return ErrNoRegion
+	case "AuthFailure", "InvalidClientTokenId", "SignatureDoesNotMatch",
+		"AccessDenied", "SharedCredsLoad", "EC2RoleRequestError":
+		return virtualmachine.WrapErrors(ErrInvalidCreds, err)
 	}
 
 	return nil
 }
 
-func getInstanceVolumeIDs(svc *ec2.EC2, instID string) ([]string, error) {
+func describeInstance(svc *ec2.EC2, instID string) (*ec2.Instance, error) {
+	resp, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{
+			aws.String(instID),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe instance: %s", err)
+	}
+
+	if len(resp.Reservations) < 1 {
+		return nil, errors.New("Missing instance reservation")
+	}
+	if len(resp.Reservations[0].Instances) < 1 {
+		return nil, ErrNoInstance
+	}
+
+	return resp.Reservations[0].Instances[0], nil
+}
+
+// instanceAddresses lists the addresses of every network interface attached
+// to the instance. Instances without interfaces (EC2-Classic) only report
+// their primary public and private addresses.
+func instanceAddresses(inst *ec2.Instance) []virtualmachine.Address {
+	addrs := []virtualmachine.Address{}
+	if len(inst.NetworkInterfaces) == 0 {
+		if ip := inst.PublicIpAddress; ip != nil {
+			addrs = append(addrs, virtualmachine.NewAddress(net.ParseIP(*ip), 0, virtualmachine.ScopePublic))
+		}
+		if ip := inst.PrivateIpAddress; ip != nil {
+			a := virtualmachine.NewAddress(net.ParseIP(*ip), 0, virtualmachine.ScopePrivate)
+			a.Network = aws.StringValue(inst.SubnetId)
+			addrs = append(addrs, a)
+		}
+		return addrs
+	}
+
+	for _, ni := range inst.NetworkInterfaces {
+		if ni == nil {
+			continue
+		}
+
+		idx := virtualmachine.NICUnknown
+		if ni.Attachment != nil && ni.Attachment.DeviceIndex != nil {
+			idx = int(*ni.Attachment.DeviceIndex)
+		}
+		newAddress := func(ip, scope string) virtualmachine.Address {
+			a := virtualmachine.NewAddress(net.ParseIP(ip), idx, scope)
+			a.Interface = aws.StringValue(ni.NetworkInterfaceId)
+			a.MAC = aws.StringValue(ni.MacAddress)
+			a.Network = aws.StringValue(ni.SubnetId)
+			return a
+		}
+
+		for _, p := range ni.PrivateIpAddresses {
+			if p == nil || p.PrivateIpAddress == nil {
+				continue
+			}
+			if p.Association != nil && p.Association.PublicIp != nil {
+				addrs = append(addrs, newAddress(*p.Association.PublicIp, virtualmachine.ScopePublic))
+			}
+			addrs = append(addrs, newAddress(*p.PrivateIpAddress, virtualmachine.ScopePrivate))
+		}
+
+		for _, v6 := range ni.Ipv6Addresses {
+			if v6 == nil || v6.Ipv6Address == nil {
+				continue
+			}
+			// EC2 only hands out global unicast IPv6 addresses.
+			addrs = append(addrs, newAddress(*v6.Ipv6Address, virtualmachine.ScopePublic))
+		}
+	}
+
+	return addrs
+}
+
+func getInstanceVolumes(svc *ec2.EC2, instID string) ([]*ec2.Volume, error) {
 	resp, err := svc.DescribeVolumes(&ec2.DescribeVolumesInput{
 		Filters: []*ec2.Filter{
 			{Name: aws.String("attachment.instance-id"),
@@ -44,8 +135,17 @@ func getInstanceVolumeIDs(svc *ec2.EC2, instID string) ([]string, error) {
 		return nil, err
 	}
 
-	ids := make([]string, 0, len(resp.Volumes))
-	for _, v := range resp.Volumes {
+	return resp.Volumes, nil
+}
+
+func getInstanceVolumeIDs(svc *ec2.EC2, instID string) ([]string, error) {
+	volumes, err := getInstanceVolumes(svc, instID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(volumes))
+	for _, v := range volumes {
 		if v == nil || v.VolumeId == nil {
 			continue
 		}
@@ -113,25 +213,39 @@ func setNonRootDeleteOnDestroy(svc *ec2.EC2, instID string, delOnTerm bool) erro
 	return nil
 }
 
-func getService(region string) *ec2.EC2 {
-	creds := credentials.NewChainCredentials(
-		[]credentials.Provider{
-			&credentials.EnvProvider{},               // check environment
-			&credentials.SharedCredentialsProvider{}, // check home dir
-		},
-	)
-
+// getRegion returns region, or the region set in the environment if it's
+// empty.
+func getRegion(region string) string {
 	if region == "" { // user didn't set region
 		region = os.Getenv("AWS_DEFAULT_REGION") // aws cli checks this
 		if region == "" {
 			region = os.Getenv("AWS_REGION") // aws sdk checks this
 		}
 	}
+	return region
+}
 
-	return ec2.New(session.New(&aws.Config{
-		Credentials: creds,
-		Region:      &region,
-	}))
+func getService(vm *VM) *ec2.EC2 {
+	return getRegionService(vm, vm.Region)
+}
+
+// getRegionService returns an EC2 client for region with the VM's credentials
+// and endpoint.
+func getRegionService(vm *VM, region string) *ec2.EC2 {
+	region = getRegion(region)
+
+	// The endpoint is only set on the EC2 client, so the STS and instance
+	// metadata clients fetching credentials keep their own.
+	sess := session.New(&aws.Config{
+		Region: &region,
+	})
+	config := &aws.Config{
+		Credentials: vm.Creds.credentials(sess),
+	}
+	if vm.Endpoint != "" {
+		config.Endpoint = aws.String(vm.Endpoint)
+	}
+	return ec2.New(sess, config)
 }
 
 func instanceInfo(vm *VM) *ec2.RunInstancesInput {
@@ -152,11 +266,13 @@ func instanceInfo(vm *VM) *ec2.RunInstancesInput {
 
 	var sgid []*string
 	if vm.SecurityGroup != "" {
-		sgid = make([]*string, 1)
-		sgid[0] = aws.String(vm.SecurityGroup)
+		sgid = append(sgid, aws.String(vm.SecurityGroup))
+	}
+	for _, sg := range vm.SecurityGroups {
+		sgid = append(sgid, aws.String(sg))
 	}
 
-	devices := make([]*ec2.BlockDeviceMapping, len(vm.Volumes))
+	devices := make([]*ec2.BlockDeviceMapping, 0, len(vm.Volumes))
 	for _, volume := range vm.Volumes {
 		if volume.VolumeSize == 0 {
 			volume.VolumeSize = defaultVolumeSize
@@ -165,17 +281,28 @@ func instanceInfo(vm *VM) *ec2.RunInstancesInput {
 			volume.VolumeType = defaultVolumeType
 		}
 
+		ebs := &ec2.EbsBlockDevice{
+			VolumeSize:          aws.Int64(int64(volume.VolumeSize)),
+			VolumeType:          aws.String(volume.VolumeType),
+			DeleteOnTermination: aws.Bool(!vm.KeepRootVolumeOnDestroy),
+		}
+		if volume.IOPS != 0 {
+			ebs.Iops = aws.Int64(int64(volume.IOPS))
+		}
+		if volume.Encrypted {
+			ebs.Encrypted = aws.Bool(true)
+		}
+		if volume.KMSKeyID != "" {
+			ebs.KmsKeyId = aws.String(volume.KMSKeyID)
+		}
+
 		devices = append(devices, &ec2.BlockDeviceMapping{
 			DeviceName: aws.String(volume.DeviceName),
-			Ebs: &ec2.EbsBlockDevice{
-				VolumeSize:          aws.Int64(int64(volume.VolumeSize)),
-				VolumeType:          aws.String(volume.VolumeType),
-				DeleteOnTermination: aws.Bool(!vm.KeepRootVolumeOnDestroy),
-			},
+			Ebs:        ebs,
 		})
 	}
 
-	return &ec2.RunInstancesInput{
+	input := &ec2.RunInstancesInput{
 		ImageId:             aws.String(vm.AMI),
 		InstanceType:        aws.String(vm.InstanceType),
 		KeyName:             aws.String(vm.KeyPair),
@@ -185,9 +312,87 @@ func instanceInfo(vm *VM) *ec2.RunInstancesInput {
 		Monitoring: &ec2.RunInstancesMonitoringEnabled{
 			Enabled: aws.Bool(true),
 		},
-		SubnetId:         sid,
-		SecurityGroupIds: sgid,
+		SubnetId:              sid,
+		SecurityGroupIds:      sgid,
+		EbsOptimized:          aws.Bool(vm.EBSOptimized),
+		DisableApiTermination: aws.Bool(vm.TerminationProtection),
+	}
+
+	if vm.PrivateIP != "" {
+		input.PrivateIpAddress = aws.String(vm.PrivateIP)
+	}
+
+	if vm.Hibernation {
+		input.HibernationOptions = &ec2.HibernationOptionsRequest{
+			Configured: aws.Bool(true),
+		}
+	}
+
+	if vm.IAMInstanceProfile != "" {
+		input.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{}
+		if strings.HasPrefix(vm.IAMInstanceProfile, "arn:") {
+			input.IamInstanceProfile.Arn = aws.String(vm.IAMInstanceProfile)
+		} else {
+			input.IamInstanceProfile.Name = aws.String(vm.IAMInstanceProfile)
+		}
+	}
+
+	if vm.AvailabilityZone != "" || vm.PlacementGroup != "" || vm.Tenancy != "" {
+		input.Placement = &ec2.Placement{}
+		if vm.AvailabilityZone != "" {
+			input.Placement.AvailabilityZone = aws.String(vm.AvailabilityZone)
+		}
+		if vm.PlacementGroup != "" {
+			input.Placement.GroupName = aws.String(vm.PlacementGroup)
+		}
+		if vm.Tenancy != "" {
+			input.Placement.Tenancy = aws.String(vm.Tenancy)
+		}
+	}
+
+	tags := map[string]string{nameTag: vm.Name}
+	for k, v := range vm.Tags {
+		tags[k] = v
+	}
+	input.TagSpecifications = tagSpecifications(tags,
+		ec2.ResourceTypeInstance, ec2.ResourceTypeVolume)
+
+	return input
+}
+
+// tagSpecifications returns the specifications that apply tags to each of the
+// resource types at creation, or nil if there are no tags.
+func tagSpecifications(tags map[string]string, resourceTypes ...string) []*ec2.TagSpecification {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	specs := make([]*ec2.TagSpecification, 0, len(resourceTypes))
+	for _, rt := range resourceTypes {
+		specs = append(specs, &ec2.TagSpecification{
+			ResourceType: aws.String(rt),
+			Tags:         ec2Tags(tags),
+		})
+	}
+	return specs
+}
+
+// ec2Tags converts tags to EC2 tags, sorted by key.
+func ec2Tags(tags map[string]string) []*ec2.Tag {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	t := make([]*ec2.Tag, 0, len(tags))
+	for _, k := range keys {
+		t = append(t, &ec2.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(tags[k]),
+		})
 	}
+	return t
 }
 
 func hasInstanceID(instance *ec2.Instance) bool {
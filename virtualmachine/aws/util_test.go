@@ -0,0 +1,119 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestTagSpecifications(t *testing.T) {
+	if specs := tagSpecifications(nil, ec2.ResourceTypeInstance); specs != nil {
+		t.Fatalf("Expected no specifications without tags, got: %v", specs)
+	}
+
+	tags := map[string]string{"team": "infra", "Name": "web-1"}
+	specs := tagSpecifications(tags, ec2.ResourceTypeInstance, ec2.ResourceTypeVolume)
+	if len(specs) != 2 {
+		t.Fatalf("Expected a specification per resource type, got: %v", specs)
+	}
+	for i, rt := range []string{ec2.ResourceTypeInstance, ec2.ResourceTypeVolume} {
+		spec := specs[i]
+		if aws.StringValue(spec.ResourceType) != rt {
+			t.Fatalf("Expected resource type %s, got: %s", rt, aws.StringValue(spec.ResourceType))
+		}
+		if len(spec.Tags) != 2 || aws.StringValue(spec.Tags[0].Key) != "Name" || aws.StringValue(spec.Tags[1].Value) != "infra" {
+			t.Fatalf("Expected the tags sorted by key, got: %v", spec.Tags)
+		}
+	}
+}
+
+func TestInstanceInfoLaunchOptions(t *testing.T) {
+	tests := []struct {
+		vm    *VM
+		check func(*ec2.RunInstancesInput) bool
+	}{
+		{
+			&VM{},
+			func(in *ec2.RunInstancesInput) bool {
+				return in.Placement == nil && in.IamInstanceProfile == nil && in.PrivateIpAddress == nil &&
+					!aws.BoolValue(in.EbsOptimized) && !aws.BoolValue(in.DisableApiTermination)
+			},
+		},
+		{
+			&VM{SecurityGroup: "sg-1", SecurityGroups: []string{"sg-2"}},
+			func(in *ec2.RunInstancesInput) bool {
+				return len(in.SecurityGroupIds) == 2 && aws.StringValue(in.SecurityGroupIds[1]) == "sg-2"
+			},
+		},
+		{
+			&VM{IAMInstanceProfile: "web"},
+			func(in *ec2.RunInstancesInput) bool {
+				return aws.StringValue(in.IamInstanceProfile.Name) == "web" && in.IamInstanceProfile.Arn == nil
+			},
+		},
+		{
+			&VM{IAMInstanceProfile: "arn:aws:iam::123456789012:instance-profile/web"},
+			func(in *ec2.RunInstancesInput) bool {
+				return in.IamInstanceProfile.Name == nil && aws.StringValue(in.IamInstanceProfile.Arn) != ""
+			},
+		},
+		{
+			&VM{AvailabilityZone: "us-west-2a", Tenancy: "dedicated"},
+			func(in *ec2.RunInstancesInput) bool {
+				p := in.Placement
+				return aws.StringValue(p.AvailabilityZone) == "us-west-2a" && p.GroupName == nil && aws.StringValue(p.Tenancy) == "dedicated"
+			},
+		},
+		{
+			&VM{PrivateIP: "10.0.0.10", EBSOptimized: true, TerminationProtection: true},
+			func(in *ec2.RunInstancesInput) bool {
+				return aws.StringValue(in.PrivateIpAddress) == "10.0.0.10" &&
+					aws.BoolValue(in.EbsOptimized) && aws.BoolValue(in.DisableApiTermination)
+			},
+		},
+	}
+	for i, test := range tests {
+		if in := instanceInfo(test.vm); !test.check(in) {
+			t.Fatalf("Unexpected input for case %d: %v", i, in)
+		}
+	}
+}
+
+func TestInstanceInfoTags(t *testing.T) {
+	vm := &VM{Name: "web-1", Tags: map[string]string{"team": "infra"}}
+	in := instanceInfo(vm)
+	if len(in.TagSpecifications) != 2 {
+		t.Fatalf("Expected the instance and its volumes to be tagged, got: %v", in.TagSpecifications)
+	}
+	tags := in.TagSpecifications[0].Tags
+	if len(tags) != 2 || aws.StringValue(tags[0].Key) != nameTag || aws.StringValue(tags[0].Value) != "web-1" {
+		t.Fatalf("Expected the name tag along with the VM's tags, got: %v", tags)
+	}
+}
+
+func TestEC2Tags(t *testing.T) {
+	tests := []struct {
+		tags map[string]string
+		keys []string
+	}{
+		{nil, []string{}},
+		{map[string]string{"b": "2", "a": "1", "c": "3"}, []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		tags := ec2Tags(test.tags)
+		keys := []string{}
+		for _, tag := range tags {
+			keys = append(keys, aws.StringValue(tag.Key))
+			if aws.StringValue(tag.Value) != test.tags[aws.StringValue(tag.Key)] {
+				t.Fatalf("Unexpected value for tag %s: %s", aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+			}
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("Expected the tags sorted by key as %v, got: %v", test.keys, keys)
+		}
+	}
+}
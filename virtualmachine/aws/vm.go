@@ -9,6 +9,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/apcera/libretto/cloudinit"
 	"github.com/apcera/libretto/ssh"
 	"github.com/apcera/libretto/util"
 	"github.com/apcera/libretto/virtualmachine"
@@ -29,6 +30,9 @@ const (
 	defaultDeviceName   = "/dev/sda1"
 	defaultVolumeType   = "gp2"
 
+	// nameTag is the tag holding the name of the VM.
+	nameTag = "Name"
+
 	// PublicIP is the index of the public IP address that GetIPs returns.
 	PublicIP = 0
 	// PrivateIP is the index of the private IP address that GetIPs returns.
@@ -54,7 +58,10 @@ const (
 )
 
 // Compiler will complain if aws.VM doesn't implement VirtualMachine interface.
-var _ virtualmachine.VirtualMachine = (*VM)(nil)
+var (
+	_ virtualmachine.VirtualMachine = (*VM)(nil)
+	_ virtualmachine.AddressLister  = (*VM)(nil)
+)
 
 // limiter rate limits channel to prevent saturating AWS API limits.
 var limiter = time.Tick(time.Millisecond * 500)
@@ -63,6 +70,9 @@ var (
 	// ErrNoCreds is returned when no credentials are found in environment or
 	// home directory.
 	ErrNoCreds = errors.New("Missing AWS credentials")
+	// ErrInvalidCreds is returned when AWS rejects the credentials or they
+	// can't be fetched from their source.
+	ErrInvalidCreds = errors.New("Invalid AWS credentials")
 	// ErrNoRegion is returned when a request was sent without a region.
 	ErrNoRegion = errors.New("Missing AWS region")
 	// ErrNoInstance is returned querying an instance, but none is found.
@@ -75,10 +85,12 @@ var (
 	ErrProvisionTimeout = errors.New("AWS provision timeout")
 	// ErrNoIPs is returned when no IP addresses are found for an instance.
 	ErrNoIPs = errors.New("Missing IPs for instance")
-	// ErrNoSupportSuspend is returned when vm.Suspend() is called.
-	ErrNoSupportSuspend = errors.New("Suspend action not supported by AWS")
-	// ErrNoSupportResume is returned when vm.Resume() is called.
-	ErrNoSupportResume = errors.New("Resume action not supported by AWS")
+	// ErrNoSupportSuspend is returned when vm.Suspend() is called on an
+	// instance launched without hibernation.
+	ErrNoSupportSuspend = errors.New("Suspend action not supported by AWS instance")
+	// ErrNoSupportResume is returned when vm.Resume() is called on an
+	// instance launched without hibernation.
+	ErrNoSupportResume = errors.New("Resume action not supported by AWS instance")
 )
 
 // VM represents an AWS EC2 virtual machine.
@@ -90,6 +102,13 @@ type VM struct {
 	InstanceID   string
 	KeyPair      string // required
 
+	// Creds selects the AWS credentials. They come from the environment or
+	// home directory by default.
+	Creds Credentials
+	// Endpoint is the URL of the EC2 API, for other partitions or EC2
+	// compatible services. The region's endpoint is used when it's empty.
+	Endpoint string
+
 	Volumes                      []EBSVolume
 	KeepRootVolumeOnDestroy      bool
 	DeleteNonRootVolumeOnDestroy bool
@@ -97,15 +116,83 @@ type VM struct {
 	VPC           string
 	Subnet        string
 	SecurityGroup string
+	// SecurityGroups are more security group IDs to launch the instance in,
+	// along with SecurityGroup.
+	SecurityGroups []string
+	// CreateSecurityGroup makes Provision create a security group for the
+	// VM with SecurityGroupRules, which allow SSH from the caller's IP
+	// address when empty, and Destroy delete it. ManagedSecurityGroupID is
+	// set to its ID.
+	CreateSecurityGroup    bool
+	SecurityGroupRules     []SecurityGroupRule
+	ManagedSecurityGroupID string
+
+	PrivateIP string
+
+	// NetworkInterfaces are attached to the instance by Provision, after its
+	// primary interface.
+	NetworkInterfaces []NetworkInterface
+
+	// ElasticIPAllocationID is an Elastic IP that Provision associates with
+	// the instance. When it's empty and AllocateElasticIP is set, Provision
+	// allocates a new one and sets it. ReleaseElasticIPOnDestroy releases it
+	// on Destroy.
+	ElasticIPAllocationID     string
+	AllocateElasticIP         bool
+	ReleaseElasticIPOnDestroy bool
+
+	AvailabilityZone string
+	PlacementGroup   string
+	Tenancy          string // "default", "dedicated" or "host"
+
+	// IAMInstanceProfile is the name or ARN of the instance profile.
+	IAMInstanceProfile string
+	EBSOptimized       bool
+	// TerminationProtection prevents the instance from being terminated
+	// through the API, so Destroy fails while it is set.
+	TerminationProtection bool
+	// Hibernation launches the instance with hibernation enabled, so Suspend
+	// and Resume work. The AMI and instance type must support it and the
+	// root volume must be encrypted.
+	Hibernation bool
+	// ForceHalt makes Halt power the instance off instead of shutting the
+	// guest OS down.
+	ForceHalt bool
+
+	// Tags are applied to the instance and its volumes when it is launched,
+	// along with a Name tag set to Name unless Tags has one.
+	Tags map[string]string
 
 	SSHCreds            ssh.Credentials // required
 	DeleteKeysOnDestroy bool
+
+	// UserData is base64-encoded user data. It can't be set together with
+	// CloudConfig.
+	UserData string
+	// CloudConfig is passed to the instance as user data when set.
+	CloudConfig *cloudinit.Config
+
+	// Spot launches a spot instance instead of an on-demand one when set.
+	Spot *SpotOptions
+	// SpotRequestID is the ID of the spot request. It is set by Provision
+	// when a spot instance was launched.
+	SpotRequestID string
 }
 
+// EBSVolume describes an EBS volume of a VM.
 type EBSVolume struct {
 	DeviceName string
-	VolumeSize int
+	VolumeSize int // GB
 	VolumeType string
+	// IOPS is the provisioned IOPS of io1 volumes.
+	IOPS      int
+	Encrypted bool
+	// KMSKeyID is the KMS key encrypted volumes use instead of the default
+	// EBS key.
+	KMSKeyID string
+
+	// VolumeID is set for the volumes returned by GetVolumes.
+	VolumeID string
 }
 
 // GetName returns the name of the virtual machine
@@ -115,7 +202,7 @@ func (vm *VM) GetName() string {
 
 // SetTag adds a tag to the VM and its attached volumes.
 func (vm *VM) SetTag(key, value string) error {
-	svc := getService(vm.Region)
+	svc := getService(vm)
 
 	if vm.InstanceID == "" {
 		return ErrNoInstanceID
@@ -148,12 +235,59 @@ func (vm *VM) SetTag(key, value string) error {
 
 // Provision creates a virtual machine on AWS. It returns an error if
 // there was a problem during creation, if there was a problem adding a tag, or
-// if the VM takes too long to enter "running" state.
-func (vm *VM) Provision() error {
+// if the VM takes too long to enter "running" state, in which case it is a
+// *ConsoleError when the console output is available. When Provision creates
+// a security group for the VM and then fails, it deletes the group, after
+// terminating the instance if one was launched.
+func (vm *VM) Provision() (err error) {
 	<-limiter
-	svc := getService(vm.Region)
+	svc := getService(vm)
+
+	input := instanceInfo(vm)
+	userData := vm.UserData
+	if vm.CloudConfig != nil {
+		if userData != "" {
+			return cloudinit.ErrUserDataConflict
+		}
+		if userData, err = vm.CloudConfig.Base64(); err != nil {
+			return err
+		}
+	}
+	if userData != "" {
+		input.UserData = aws.String(userData)
+	}
+	if vm.Spot != nil {
+		if err = vm.Spot.validate(); err != nil {
+			return err
+		}
+	}
+	if vm.CreateSecurityGroup {
+		if err = validateSecurityGroupRules(vm.SecurityGroupRules); err != nil {
+			return err
+		}
+	}
+
+	if vm.CreateSecurityGroup && vm.ManagedSecurityGroupID == "" {
+		err = createSecurityGroup(svc, vm)
+		if vm.ManagedSecurityGroupID != "" {
+			defer func() {
+				if err == nil {
+					return
+				}
+				if cerr := removeSecurityGroup(svc, vm); cerr != nil {
+					err = virtualmachine.WrapErrors(err, cerr)
+				}
+			}()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if vm.ManagedSecurityGroupID != "" {
+		input.SecurityGroupIds = append(input.SecurityGroupIds, aws.String(vm.ManagedSecurityGroupID))
+	}
 
-	resp, err := svc.RunInstances(instanceInfo(vm))
+	resp, err := runInstances(svc, vm, input)
 	if err != nil {
 		return fmt.Errorf("Failed to create instance: %v", err)
 	}
@@ -163,6 +297,7 @@ func (vm *VM) Provision() error {
 	} else {
 		return ErrNoInstanceID
 	}
+	vm.SpotRequestID = aws.StringValue(resp.Instances[0].SpotInstanceRequestId)
 
 	instID := []*string{
 		aws.String(vm.InstanceID),
@@ -178,61 +313,107 @@ func (vm *VM) Provision() error {
 		InstanceIds: instID,
 	})
 	if err != nil {
-		return fmt.Errorf("Failed to wait for instance to run: %s", err)
+		werr := fmt.Errorf("Failed to wait for instance to run: %s", err)
+		if isWaiterTimeout(err) {
+			return vm.withConsole(werr)
+		}
+		return werr
 	}
 
 	if vm.DeleteNonRootVolumeOnDestroy {
-		return setNonRootDeleteOnDestroy(svc, vm.InstanceID, true)
+		if err := setNonRootDeleteOnDestroy(svc, vm.InstanceID, true); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	if err := attachNetworkInterfaces(svc, vm); err != nil {
+		return err
+	}
+
+	return associateElasticIP(svc, vm)
 }
 
 // GetIPs returns a slice of IP addresses assigned to the VM. The PublicIP or
-// PrivateIP consts can be used to retrieve respective IP address type. It
-// returns nil if there was an error obtaining the IPs.
+// PrivateIP consts can be used to retrieve respective IP address type, and the
+// private addresses of the other network interfaces follow them. The public IP
+// is the Elastic IP once one is associated. It returns nil if there was an
+// error obtaining the IPs.
 func (vm *VM) GetIPs() ([]net.IP, error) {
-	svc := getService(vm.Region)
+	svc := getService(vm)
 	if vm.InstanceID == "" {
 		// Probably need to call Provision first.
 		return nil, ErrNoInstanceID
 	}
 
-	inst, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
-		InstanceIds: []*string{
-			aws.String(vm.InstanceID),
-		},
-	})
+	inst, err := describeInstance(svc, vm.InstanceID)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to describe instance: %s", err)
-	}
-
-	if len(inst.Reservations) < 1 {
-		return nil, errors.New("Missing instance reservation")
-	}
-	if len(inst.Reservations[0].Instances) < 1 {
-		return nil, ErrNoInstance
+		return nil, err
 	}
 
 	ips := make([]net.IP, 2)
-	if ip := inst.Reservations[0].Instances[0].PublicIpAddress; ip != nil {
+	if ip := inst.PublicIpAddress; ip != nil {
 		ips[PublicIP] = net.ParseIP(*ip)
 	}
-	if ip := inst.Reservations[0].Instances[0].PrivateIpAddress; ip != nil {
+	if ip := inst.PrivateIpAddress; ip != nil {
 		ips[PrivateIP] = net.ParseIP(*ip)
 	}
 
+	for _, ni := range inst.NetworkInterfaces {
+		if ni == nil {
+			continue
+		}
+		for _, p := range ni.PrivateIpAddresses {
+			if p == nil || p.PrivateIpAddress == nil {
+				continue
+			}
+			if ip := net.ParseIP(*p.PrivateIpAddress); !ip.Equal(ips[PrivateIP]) {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
 	return ips, nil
 }
 
-// Destroy terminates the VM on AWS. It returns an error if AWS credentials are
-// missing or if there is no instance ID.
+// GetAddresses returns every address currently assigned to the VM's network
+// interfaces, including secondary private IPs, their associated public IPs and
+// IPv6 addresses. It does not wait for the instance to get an address.
+func (vm *VM) GetAddresses() ([]virtualmachine.Address, error) {
+	svc := getService(vm)
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return nil, ErrNoInstanceID
+	}
+
+	inst, err := describeInstance(svc, vm.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return instanceAddresses(inst), nil
+}
+
+// Destroy terminates the VM on AWS, after cancelling its spot request if it
+// is a spot instance and releasing its Elastic IP if the VM asks for it. A
+// security group created by libretto is deleted once the instance is
+// terminated. It returns an error if AWS credentials are missing or if there
+// is no instance ID.
 func (vm *VM) Destroy() error {
-	svc := getService(vm.Region)
+	svc := getService(vm)
 	if vm.InstanceID == "" {
 		// Probably need to call Provision first.
 		return ErrNoInstanceID
 	}
+	if vm.SpotRequestID != "" {
+		if err := cancelSpotRequest(svc, vm); err != nil {
+			return err
+		}
+	}
+	if vm.ReleaseElasticIPOnDestroy && vm.ElasticIPAllocationID != "" {
+		if err := releaseElasticIP(svc, vm); err != nil {
+			return err
+		}
+	}
 	_, err := svc.TerminateInstances(&ec2.TerminateInstancesInput{
 		InstanceIds: []*string{
 			aws.String(vm.InstanceID),
@@ -242,6 +423,12 @@ func (vm *VM) Destroy() error {
 		return err
 	}
 
+	if vm.ManagedSecurityGroupID != "" {
+		if err := deleteSecurityGroup(svc, vm); err != nil {
+			return err
+		}
+	}
+
 	if !vm.DeleteKeysOnDestroy {
 		return nil
 	}
@@ -249,8 +436,11 @@ func (vm *VM) Destroy() error {
 	return vm.DeleteKeyPair()
 }
 
-// GetSSH returns an SSH client that can be used to connect to a VM. An error
-// is returned if the VM has no IPs.
+// GetSSH returns an SSH client that can be used to connect to a VM. The
+// address is picked by options.Target, which prefers the public address by
+// default. An error is returned if the VM has no IPs, and a *ConsoleError,
+// when the console output is available, if SSH doesn't come up within
+// SSHTimeout.
 func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 	ips, err := util.GetVMIPs(vm, options)
 	if err != nil {
@@ -259,11 +449,14 @@ func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 
 	client := &ssh.SSHClient{
 		Creds:   &vm.SSHCreds,
-		IP:      ips[PublicIP],
+		IP:      ips[0],
 		Options: options,
 		Port:    22,
 	}
 	if err := client.WaitForSSH(SSHTimeout); err != nil {
+		if err == ssh.ErrTimeout {
+			return nil, vm.withConsole(err)
+		}
 		return nil, err
 	}
 	return client, nil
@@ -273,7 +466,7 @@ func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 // returned if the instance ID is missing, if there was a problem querying AWS,
 // or if there are no instances.
 func (vm *VM) GetState() (string, error) {
-	svc := getService(vm.Region)
+	svc := getService(vm)
 
 	if vm.InstanceID == "" {
 		// Probably need to call Provision first.
@@ -299,59 +492,122 @@ func (vm *VM) GetState() (string, error) {
 	return *stat.Reservations[0].Instances[0].State.Name, nil
 }
 
-// Halt shuts down the VM on AWS.
+// Halt shuts down the VM on AWS and waits for it to be stopped. The guest OS
+// is shut down gracefully unless ForceHalt is set.
 func (vm *VM) Halt() error {
-	svc := getService(vm.Region)
+	return vm.stop(vm.ForceHalt, false)
+}
 
+// Start boots a stopped VM and waits for it to be running.
+func (vm *VM) Start() error {
 	if vm.InstanceID == "" {
 		// Probably need to call Provision first.
 		return ErrNoInstanceID
 	}
 
-	_, err := svc.StopInstances(&ec2.StopInstancesInput{
+	svc := getService(vm)
+	_, err := svc.StartInstances(&ec2.StartInstancesInput{
 		InstanceIds: []*string{
 			aws.String(vm.InstanceID),
 		},
 		DryRun: aws.Bool(false),
-		Force:  aws.Bool(true),
 	})
 	if err != nil {
-		return fmt.Errorf("Failed to stop instance: %v", err)
+		return fmt.Errorf("Failed to start instance: %v", err)
+	}
+
+	err = svc.WaitUntilInstanceRunning(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(vm.InstanceID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to wait for instance to run: %s", err)
 	}
 
 	return nil
 }
 
-// Start boots a stopped VM.
-func (vm *VM) Start() error {
-	svc := getService(vm.Region)
+// Suspend hibernates the VM and waits for it to be stopped. It returns
+// ErrNoSupportSuspend unless the instance was launched with Hibernation.
+func (vm *VM) Suspend() error {
+	ok, err := vm.canHibernate()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNoSupportSuspend
+	}
+	return vm.stop(false, true)
+}
+
+// Resume starts a hibernated VM and waits for it to be running. It returns
+// ErrNoSupportResume unless the instance was launched with Hibernation.
+func (vm *VM) Resume() error {
+	ok, err := vm.canHibernate()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNoSupportResume
+	}
+	return vm.Start()
+}
 
+// canHibernate reports whether the instance was launched with hibernation
+// enabled.
+func (vm *VM) canHibernate() (bool, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return false, ErrNoInstanceID
+	}
+
+	inst, err := describeInstance(getService(vm), vm.InstanceID)
+	if err != nil {
+		return false, err
+	}
+	return hibernationConfigured(inst), nil
+}
+
+// hibernationConfigured returns true if inst was launched with hibernation
+// enabled.
+func hibernationConfigured(inst *ec2.Instance) bool {
+	return inst.HibernationOptions != nil && aws.BoolValue(inst.HibernationOptions.Configured)
+}
+
+// stop stops the instance, hibernating it if hibernate is set, and waits for
+// it to be stopped.
+func (vm *VM) stop(force, hibernate bool) error {
 	if vm.InstanceID == "" {
 		// Probably need to call Provision first.
 		return ErrNoInstanceID
 	}
 
-	_, err := svc.StartInstances(&ec2.StartInstancesInput{
-		InstanceIds: []*string{
-			aws.String(vm.InstanceID),
-		},
-		DryRun: aws.Bool(false),
+	svc := getService(vm)
+	_, err := svc.StopInstances(stopInstancesInput(vm.InstanceID, force, hibernate))
+	if err != nil {
+		return fmt.Errorf("Failed to stop instance: %v", err)
+	}
+
+	err = svc.WaitUntilInstanceStopped(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(vm.InstanceID)},
 	})
 	if err != nil {
-		return fmt.Errorf("Failed to start instance: %v", err)
+		return fmt.Errorf("Failed to wait for instance to stop: %s", err)
 	}
 
 	return nil
 }
 
-// Suspend always returns an error because this isn't supported by AWS.
-func (vm *VM) Suspend() error {
-	return ErrNoSupportSuspend
-}
-
-// Resume always returns an error because this isn't supported by AWS.
-func (vm *VM) Resume() error {
-	return ErrNoSupportResume
+// stopInstancesInput returns the input stopping the instance instID, skipping
+// the graceful shutdown of the guest OS if force is set.
+func stopInstancesInput(instID string, force, hibernate bool) *ec2.StopInstancesInput {
+	return &ec2.StopInstancesInput{
+		InstanceIds: []*string{
+			aws.String(instID),
+		},
+		DryRun:    aws.Bool(false),
+		Force:     aws.Bool(force),
+		Hibernate: aws.Bool(hibernate),
+	}
 }
 
 // UseKeyPair uploads the public part of a keypair to AWS with a given name
@@ -363,7 +619,7 @@ func (vm *VM) UseKeyPair(kp *ssh.KeyPair, name string) error {
 		return errors.New("Key pair can't be nil.")
 	}
 
-	svc := getService(vm.Region)
+	svc := getService(vm)
 
 	_, err := svc.ImportKeyPair(&ec2.ImportKeyPairInput{
 		KeyName:           aws.String(name),
@@ -386,7 +642,7 @@ func (vm *VM) UseKeyPair(kp *ssh.KeyPair, name string) error {
 
 // DeleteKeyPair deletes the key pair set for this VM.
 func (vm *VM) DeleteKeyPair() error {
-	svc := getService(vm.Region)
+	svc := getService(vm)
 
 	if vm.KeyPair == "" {
 		return errors.New("Missing key pair name")
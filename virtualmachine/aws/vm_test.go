@@ -0,0 +1,55 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestStopInstancesInput(t *testing.T) {
+	tests := []struct {
+		force, hibernate   bool
+		wantForce, wantHib bool
+	}{
+		{false, false, false, false},
+		{true, false, true, false},
+		{false, true, false, true},
+		{true, true, true, true},
+	}
+	for _, test := range tests {
+		in := stopInstancesInput("i-1", test.force, test.hibernate)
+		if len(in.InstanceIds) != 1 || aws.StringValue(in.InstanceIds[0]) != "i-1" {
+			t.Fatalf("Expected to stop i-1, got: %v", in.InstanceIds)
+		}
+		if aws.BoolValue(in.Force) != test.wantForce || aws.BoolValue(in.Hibernate) != test.wantHib {
+			t.Fatalf("Expected force %v and hibernate %v for %+v, got: %v", test.wantForce, test.wantHib, test, in)
+		}
+	}
+}
+
+func TestHibernation(t *testing.T) {
+	tests := []struct {
+		opts *ec2.HibernationOptions
+		want bool
+	}{
+		{nil, false},
+		{&ec2.HibernationOptions{}, false},
+		{&ec2.HibernationOptions{Configured: aws.Bool(false)}, false},
+		{&ec2.HibernationOptions{Configured: aws.Bool(true)}, true},
+	}
+	for _, test := range tests {
+		if got := hibernationConfigured(&ec2.Instance{HibernationOptions: test.opts}); got != test.want {
+			t.Fatalf("Expected %v for %v, got: %v", test.want, test.opts, got)
+		}
+	}
+
+	if in := instanceInfo(&VM{}); in.HibernationOptions != nil {
+		t.Fatalf("Expected no hibernation options by default, got: %v", in.HibernationOptions)
+	}
+	if in := instanceInfo(&VM{Hibernation: true}); !aws.BoolValue(in.HibernationOptions.Configured) {
+		t.Fatalf("Expected hibernation to be configured, got: %v", in.HibernationOptions)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// VolumeModificationTimeout is the maximum time to wait for a resized
+	// volume to reach the "optimizing" state.
+	VolumeModificationTimeout = 10 * time.Minute
+
+	volumeModificationPoll = 5 * time.Second
+)
+
+// ErrVolumeModificationTimeout is returned when a resized volume takes too
+// long to reach the "optimizing" state.
+var ErrVolumeModificationTimeout = errors.New("AWS volume modification timeout")
+
+// AttachVolume creates a volume as described by volume in the instance's
+// availability zone, attaches it at volume.DeviceName and returns its ID. The
+// volume gets the VM's tags, and is deleted with the instance if
+// DeleteNonRootVolumeOnDestroy is set.
+func (vm *VM) AttachVolume(volume EBSVolume) (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+	if volume.DeviceName == "" {
+		return "", errors.New("Missing volume device name")
+	}
+	if volume.VolumeSize == 0 {
+		volume.VolumeSize = defaultVolumeSize
+	}
+	if volume.VolumeType == "" {
+		volume.VolumeType = defaultVolumeType
+	}
+
+	svc := getService(vm)
+	inst, err := describeInstance(svc, vm.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	if inst.Placement == nil || inst.Placement.AvailabilityZone == nil {
+		return "", errors.New("Missing instance availability zone")
+	}
+
+	input := createVolumeInput(volume)
+	input.AvailabilityZone = inst.Placement.AvailabilityZone
+	input.TagSpecifications = tagSpecifications(vm.Tags, ec2.ResourceTypeVolume)
+	resp, err := svc.CreateVolume(input)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create volume: %s", err)
+	}
+	volID := aws.StringValue(resp.VolumeId)
+	err = svc.WaitUntilVolumeAvailable(&ec2.DescribeVolumesInput{
+		VolumeIds: []*string{resp.VolumeId},
+	})
+	if err != nil {
+		return volID, fmt.Errorf("Failed to wait for volume to be available: %s", err)
+	}
+
+	if err := attachVolume(svc, vm.InstanceID, volID, volume.DeviceName); err != nil {
+		return volID, err
+	}
+
+	if vm.DeleteNonRootVolumeOnDestroy {
+		return volID, setNonRootDeleteOnDestroy(svc, vm.InstanceID, true)
+	}
+	return volID, nil
+}
+
+// DetachVolume detaches the volume volumeID from the VM and waits for it to be
+// available. The volume isn't deleted.
+func (vm *VM) DetachVolume(volumeID string) error {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return ErrNoInstanceID
+	}
+
+	return detachVolume(getService(vm), vm.InstanceID, volumeID)
+}
+
+// ResizeVolume grows the volume volumeID to size GB and waits until AWS
+// starts optimizing it, at which point the new size can be used by growing the
+// file system.
+func (vm *VM) ResizeVolume(volumeID string, size int) error {
+	svc := getService(vm)
+	_, err := svc.ModifyVolume(&ec2.ModifyVolumeInput{
+		VolumeId: aws.String(volumeID),
+		Size:     aws.Int64(int64(size)),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to modify volume: %s", err)
+	}
+
+	deadline := time.Now().Add(VolumeModificationTimeout)
+	for time.Now().Before(deadline) {
+		resp, err := svc.DescribeVolumesModifications(&ec2.DescribeVolumesModificationsInput{
+			VolumeIds: []*string{aws.String(volumeID)},
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to describe volume modification: %s", err)
+		}
+		for _, m := range resp.VolumesModifications {
+			if m == nil {
+				continue
+			}
+			switch aws.StringValue(m.ModificationState) {
+			case ec2.VolumeModificationStateOptimizing, ec2.VolumeModificationStateCompleted:
+				return nil
+			case ec2.VolumeModificationStateFailed:
+				return fmt.Errorf("Failed to modify volume: %s", aws.StringValue(m.StatusMessage))
+			}
+		}
+		time.Sleep(volumeModificationPoll)
+	}
+
+	return ErrVolumeModificationTimeout
+}
+
+// GetVolumes returns the EBS volumes attached to the VM, with their IDs.
+func (vm *VM) GetVolumes() ([]EBSVolume, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return nil, ErrNoInstanceID
+	}
+
+	volumes, err := getInstanceVolumes(getService(vm), vm.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get instance's volumes: %s", err)
+	}
+
+	ebs := make([]EBSVolume, 0, len(volumes))
+	for _, v := range volumes {
+		if v == nil || v.VolumeId == nil {
+			continue
+		}
+		ebs = append(ebs, ebsVolume(v, vm.InstanceID))
+	}
+
+	return ebs, nil
+}
+
+// createVolumeInput returns the input creating a volume as described by
+// volume, without its availability zone and tags.
+func createVolumeInput(volume EBSVolume) *ec2.CreateVolumeInput {
+	input := &ec2.CreateVolumeInput{
+		Size:       aws.Int64(int64(volume.VolumeSize)),
+		VolumeType: aws.String(volume.VolumeType),
+		Encrypted:  aws.Bool(volume.Encrypted),
+	}
+	if volume.IOPS != 0 {
+		input.Iops = aws.Int64(int64(volume.IOPS))
+	}
+	if volume.KMSKeyID != "" {
+		input.KmsKeyId = aws.String(volume.KMSKeyID)
+	}
+	return input
+}
+
+// ebsVolume converts v to an EBSVolume, with the device it's attached at on
+// the instance instID.
+func ebsVolume(v *ec2.Volume, instID string) EBSVolume {
+	volume := EBSVolume{
+		VolumeID:   aws.StringValue(v.VolumeId),
+		VolumeSize: int(aws.Int64Value(v.Size)),
+		VolumeType: aws.StringValue(v.VolumeType),
+		IOPS:       int(aws.Int64Value(v.Iops)),
+		Encrypted:  aws.BoolValue(v.Encrypted),
+		KMSKeyID:   aws.StringValue(v.KmsKeyId),
+	}
+	for _, a := range v.Attachments {
+		if a != nil && aws.StringValue(a.InstanceId) == instID {
+			volume.DeviceName = aws.StringValue(a.Device)
+		}
+	}
+	return volume
+}
+
+func attachVolume(svc *ec2.EC2, instID, volID, device string) error {
+	_, err := svc.AttachVolume(&ec2.AttachVolumeInput{
+		Device:     aws.String(device),
+		InstanceId: aws.String(instID),
+		VolumeId:   aws.String(volID),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to attach volume: %s", err)
+	}
+	err = svc.WaitUntilVolumeInUse(&ec2.DescribeVolumesInput{
+		VolumeIds: []*string{aws.String(volID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to wait for volume to attach: %s", err)
+	}
+	return nil
+}
+
+func detachVolume(svc *ec2.EC2, instID, volID string) error {
+	_, err := svc.DetachVolume(&ec2.DetachVolumeInput{
+		InstanceId: aws.String(instID),
+		VolumeId:   aws.String(volID),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to detach volume: %s", err)
+	}
+	err = svc.WaitUntilVolumeAvailable(&ec2.DescribeVolumesInput{
+		VolumeIds: []*string{aws.String(volID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to wait for volume to detach: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestCreateVolumeInput(t *testing.T) {
+	tests := []struct {
+		volume EBSVolume
+		iops   *int64
+		kms    *string
+	}{
+		{EBSVolume{VolumeSize: 10, VolumeType: "gp2"}, nil, nil},
+		{EBSVolume{VolumeSize: 100, VolumeType: "io1", IOPS: 5000}, aws.Int64(5000), nil},
+		{EBSVolume{VolumeSize: 10, VolumeType: "gp2", Encrypted: true, KMSKeyID: "alias/ebs"}, nil, aws.String("alias/ebs")},
+	}
+	for _, test := range tests {
+		in := createVolumeInput(test.volume)
+		if aws.Int64Value(in.Size) != int64(test.volume.VolumeSize) || aws.StringValue(in.VolumeType) != test.volume.VolumeType {
+			t.Fatalf("Expected a %d GiB %s volume, got: %v", test.volume.VolumeSize, test.volume.VolumeType, in)
+		}
+		if aws.BoolValue(in.Encrypted) != test.volume.Encrypted {
+			t.Fatalf("Expected encrypted to be %v, got: %v", test.volume.Encrypted, in)
+		}
+		if !reflect.DeepEqual(in.Iops, test.iops) || !reflect.DeepEqual(in.KmsKeyId, test.kms) {
+			t.Fatalf("Unexpected IOPS or KMS key for %+v, got: %v", test.volume, in)
+		}
+	}
+}
+
+func TestEBSVolume(t *testing.T) {
+	v := &ec2.Volume{
+		VolumeId:   aws.String("vol-1"),
+		Size:       aws.Int64(20),
+		VolumeType: aws.String("io1"),
+		Iops:       aws.Int64(1000),
+		Encrypted:  aws.Bool(true),
+		KmsKeyId:   aws.String("arn:aws:kms:us-west-2:123456789012:key/1"),
+		Attachments: []*ec2.VolumeAttachment{
+			{InstanceId: aws.String("i-2"), Device: aws.String("/dev/sdg")},
+			{InstanceId: aws.String("i-1"), Device: aws.String("/dev/sdf")},
+		},
+	}
+	want := EBSVolume{
+		VolumeID:   "vol-1",
+		DeviceName: "/dev/sdf",
+		VolumeSize: 20,
+		VolumeType: "io1",
+		IOPS:       1000,
+		Encrypted:  true,
+		KMSKeyID:   "arn:aws:kms:us-west-2:123456789012:key/1",
+	}
+	if got := ebsVolume(v, "i-1"); got != want {
+		t.Fatalf("Expected %+v, got: %+v", want, got)
+	}
+}
+
+func TestInstanceInfoVolumes(t *testing.T) {
+	vm := &VM{
+		KeepRootVolumeOnDestroy: true,
+		Volumes: []EBSVolume{
+			{DeviceName: "/dev/sda1"},
+			{DeviceName: "/dev/sdf", VolumeSize: 100, VolumeType: "io1", IOPS: 5000, Encrypted: true},
+		},
+	}
+	devices := instanceInfo(vm).BlockDeviceMappings
+	if len(devices) != 2 {
+		t.Fatalf("Expected 2 block devices, got: %v", devices)
+	}
+	root := devices[0].Ebs
+	if aws.Int64Value(root.VolumeSize) != defaultVolumeSize || aws.StringValue(root.VolumeType) != defaultVolumeType ||
+		aws.BoolValue(root.DeleteOnTermination) || root.Iops != nil || root.Encrypted != nil {
+		t.Fatalf("Expected a default volume kept on termination, got: %v", root)
+	}
+	data := devices[1].Ebs
+	if aws.StringValue(devices[1].DeviceName) != "/dev/sdf" || aws.Int64Value(data.Iops) != 5000 || !aws.BoolValue(data.Encrypted) {
+		t.Fatalf("Unexpected data volume: %v", devices[1])
+	}
+}
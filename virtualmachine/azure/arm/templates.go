@@ -11,6 +11,10 @@ const Linux = `{
     "password": {
       "type": "string"
     },
+    "custom_data": {
+      "type": "string",
+      "defaultValue": ""
+    },
     "image_publisher": {
       "type": "string"
     },
@@ -117,6 +121,7 @@ const Linux = `{
           "computerName": "[parameters('vm_name')]",
           "adminUsername": "[parameters('username')]",
           "adminPassword": "[parameters('password')]",
+          "customData": "[parameters('custom_data')]",
           "linuxConfiguration": {
             "disablePasswordAuthentication": "false"
           }
@@ -35,6 +35,7 @@ type armParameter struct {
 type armParameters struct {
 	AdminUsername        *armParameter `json:"username,omitempty"`
 	AdminPassword        *armParameter `json:"password,omitempty"`
+	CustomData           *armParameter `json:"custom_data,omitempty"`
 	ImageOffer           *armParameter `json:"image_offer,omitempty"`
 	ImagePublisher       *armParameter `json:"image_publisher,omitempty"`
 	ImageSku             *armParameter `json:"image_sku,omitempty"`
@@ -140,6 +141,13 @@ func (vm *VM) deploy() error {
 
 	// Pass the parameters to the arm templacte
 	vmParams := vm.toARMParameters()
+	if vm.CloudConfig != nil {
+		customData, err := vm.CloudConfig.Base64()
+		if err != nil {
+			return err
+		}
+		vmParams.CustomData = &armParameter{customData}
+	}
 	deployment, err := createDeployment(Linux, *vmParams)
 	if err != nil {
 		return err
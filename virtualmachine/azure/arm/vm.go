@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/apcera/libretto/cloudinit"
 	"github.com/apcera/libretto/ssh"
 	"github.com/apcera/libretto/util"
 	lvm "github.com/apcera/libretto/virtualmachine"
@@ -52,7 +53,10 @@ const (
 	maxPublicIPLength = 63
 )
 
-var _ lvm.VirtualMachine = (*VM)(nil)
+var (
+	_ lvm.VirtualMachine = (*VM)(nil)
+	_ lvm.AddressLister  = (*VM)(nil)
+)
 
 // OAuthCredentials is the struct that stors OAUTH credentials
 type OAuthCredentials struct {
@@ -93,6 +97,9 @@ type VM struct {
 	PublicIP             string
 	Subnet               string
 	VirtualNetwork       string
+
+	// CloudConfig is passed to the VM as custom data when set.
+	CloudConfig *cloudinit.Config
 }
 
 // GetName returns the name of the VM.
@@ -159,8 +166,30 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	return ips, nil
 }
 
-// GetSSH returns an SSH client that can be used to connect to the VM. An error
-// is returned if the VM has no IPs.
+// GetAddresses returns the public and private addresses of the VM's network
+// interface.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
+	ips, err := vm.GetIPs()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []lvm.Address{}
+	for i, scope := range []string{lvm.ScopePublic, lvm.ScopePrivate} {
+		if ips[i] == nil {
+			continue
+		}
+		a := lvm.NewAddress(ips[i], 0, scope)
+		a.Interface = vm.Nic
+		a.Network = vm.Subnet
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+// GetSSH returns an SSH client that can be used to connect to the VM. The
+// address is picked by options.Target. An error is returned if the VM has no
+// IPs.
 func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 	ips, err := util.GetVMIPs(vm, options)
 	if err != nil {
@@ -169,7 +198,7 @@ func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 
 	client := ssh.SSHClient{
 		Creds:   &vm.SSHCreds,
-		IP:      ips[PublicIP],
+		IP:      ips[0],
 		Options: options,
 		Port:    22,
 	}
@@ -171,6 +171,22 @@ func (vm *VM) getDeploymentOptions() virtualmachine.CreateDeploymentOptions {
 	return virtualmachine.CreateDeploymentOptions{VirtualNetworkName: vm.DeployOptions.VirtualNetworkName}
 }
 
+// configureCustomData sets the VM's cloud config as the custom data of the
+// role's Linux provisioning configuration set.
+func (vm *VM) configureCustomData(role *virtualmachine.Role) error {
+	customData, err := vm.CloudConfig.Base64()
+	if err != nil {
+		return err
+	}
+	for i := range role.ConfigurationSets {
+		if role.ConfigurationSets[i].ConfigurationSetType == virtualmachine.ConfigurationSetTypeLinuxProvisioning {
+			role.ConfigurationSets[i].CustomData = customData
+			return nil
+		}
+	}
+	return fmt.Errorf("No Linux provisioning configuration set found")
+}
+
 // getFirstSubnet gets the name of the first subnet within the VM's virtual
 // network.
 func (vm *VM) getFirstSubnet() (string, error) {
@@ -12,6 +12,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/management/vmutils"
 	"github.com/apcera/libretto/util"
 
+	"github.com/apcera/libretto/cloudinit"
 	"github.com/apcera/libretto/ssh"
 	lvm "github.com/apcera/libretto/virtualmachine"
 )
@@ -33,7 +34,10 @@ const (
 	errProvisionVM    = "Error to provision Azure VM %s"
 )
 
-var _ lvm.VirtualMachine = (*VM)(nil)
+var (
+	_ lvm.VirtualMachine = (*VM)(nil)
+	_ lvm.AddressLister  = (*VM)(nil)
+)
 
 // VM represents an Azure virtual machine.
 type VM struct {
@@ -50,6 +54,7 @@ type VM struct {
 	DeployOptions    DeploymentOptions // optional
 	ConfigureHTTP    bool              // Flag to configure HTTP endpoint for the VM
 	Cert             Certificated
+	CloudConfig      *cloudinit.Config // optional, passed to the VM as custom data
 }
 
 // DeploymentOptions contains the names of some Azure networking options.
@@ -117,6 +122,12 @@ func (vm *VM) Provision() error {
 		return fmt.Errorf(errProvisionVM, err)
 	}
 
+	if vm.CloudConfig != nil {
+		if err = vm.configureCustomData(&role); err != nil {
+			return fmt.Errorf(errProvisionVM, err)
+		}
+	}
+
 	err = vmutils.ConfigureWithPublicSSH(&role)
 	if err != nil {
 		return fmt.Errorf(errProvisionVM, err)
@@ -170,8 +181,29 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	return ips, nil
 }
 
-// GetSSH returns an SSH client that can be used to connect to the VM. An error
-// is returned if the VM has no IPs.
+// GetAddresses returns the public virtual IP of the hosted service and the
+// private address of the role instance.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
+	ips, err := vm.GetIPs()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []lvm.Address{}
+	if ip := ips[PublicIP]; ip != nil {
+		addrs = append(addrs, lvm.NewAddress(ip, 0, lvm.ScopePublic))
+	}
+	if ip := ips[PrivateIP]; ip != nil {
+		a := lvm.NewAddress(ip, 0, lvm.ScopePrivate)
+		a.Network = vm.DeployOptions.VirtualNetworkName
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+// GetSSH returns an SSH client that can be used to connect to the VM. The
+// address is picked by options.Target. An error is returned if the VM has no
+// IPs.
 func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 	ips, err := util.GetVMIPs(vm, options)
 	if err != nil {
@@ -180,7 +212,7 @@ func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 
 	client := ssh.SSHClient{
 		Creds:   &vm.SSHCreds,
-		IP:      ips[PublicIP],
+		IP:      ips[0],
 		Options: options,
 		Port:    22,
 	}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+
+	lvm "github.com/apcera/libretto/virtualmachine"
 )
 
 // BuildRequest builds an http request for this provider.
@@ -29,6 +31,17 @@ func (vm *VM) Update() error {
 	return err
 }
 
+// dropletNIC returns the interface index for a DigitalOcean network type.
+func dropletNIC(networkType string) int {
+	switch networkType {
+	case lvm.ScopePublic:
+		return 0
+	case lvm.ScopePrivate:
+		return 1
+	}
+	return lvm.NICUnknown
+}
+
 // GetDroplet returns a single droplet
 func GetDroplet(token, id string) (*Droplet, error) {
 	client := &http.Client{}
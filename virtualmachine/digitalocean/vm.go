@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/apcera/libretto/cloudinit"
 	libssh "github.com/apcera/libretto/ssh"
 	"github.com/apcera/libretto/util"
 	lvm "github.com/apcera/libretto/virtualmachine"
@@ -37,9 +38,15 @@ type VM struct {
 	Credentials libssh.Credentials
 	Config      Config
 	Droplet     *Droplet
+	// CloudConfig is sent as the droplet's user data. It can't be combined
+	// with Config.UserData.
+	CloudConfig *cloudinit.Config
 }
 
-var _ lvm.VirtualMachine = (*VM)(nil)
+var (
+	_ lvm.VirtualMachine = (*VM)(nil)
+	_ lvm.AddressLister  = (*VM)(nil)
+)
 
 // Config is the new droplet payload
 type Config struct {
@@ -163,7 +170,14 @@ func (vm *VM) GetName() string {
 
 // Provision creates a new VM
 func (vm *VM) Provision() error {
-	b, err := json.Marshal(vm.Config)
+	config := vm.Config
+	userData, err := cloudinit.UserData(config.UserData, vm.CloudConfig)
+	if err != nil {
+		return err
+	}
+	config.UserData = userData
+
+	b, err := json.Marshal(config)
 	if err != nil {
 		return err
 	}
@@ -211,6 +225,34 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	return ips, nil
 }
 
+// GetAddresses returns the addresses of the droplet. Droplets have their
+// public network on the first interface and the private one on the second.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
+	if err := vm.Update(); err != nil {
+		return nil, err
+	}
+
+	addrs := []lvm.Address{}
+	if vm.Droplet.Networks == nil {
+		return addrs, nil
+	}
+	for _, n := range vm.Droplet.Networks.V4 {
+		if ip := net.ParseIP(n.IPAddress); ip != nil {
+			a := lvm.NewAddress(ip, dropletNIC(n.Type), n.Type)
+			a.Network = n.Type
+			addrs = append(addrs, a)
+		}
+	}
+	for _, n := range vm.Droplet.Networks.V6 {
+		if ip := net.ParseIP(n.IPAddress); ip != nil {
+			a := lvm.NewAddress(ip, dropletNIC(n.Type), n.Type)
+			a.Network = n.Type
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs, nil
+}
+
 // GetSSH returns an ssh client for the the vm.
 func (vm *VM) GetSSH(options libssh.Options) (libssh.Client, error) {
 	ips, err := util.GetVMIPs(vm, options)
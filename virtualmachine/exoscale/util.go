@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/apcera/libretto/virtualmachine"
 	"github.com/pyr/egoscale/src/egoscale"
 )
 
@@ -233,16 +234,25 @@ func (vm *VM) updateInfo() error {
 
 	// suppose 1 ip per nic, and grow capacity using append if necessary
 	ips := make([]net.IP, 0, len(listVM.VirtualMachines[0].Nic))
-	for _, nic := range listVM.VirtualMachines[0].Nic {
-		if nic.Ipaddress != "" {
-			ips = append(ips, net.ParseIP(nic.Ipaddress))
-		}
-		if nic.Ip6address != "" {
-			ips = append(ips, net.ParseIP(nic.Ip6address))
+	addrs := make([]virtualmachine.Address, 0, len(listVM.VirtualMachines[0].Nic))
+	for i, nic := range listVM.VirtualMachines[0].Nic {
+		for _, s := range []string{nic.Ipaddress, nic.Ip6address} {
+			if s == "" {
+				continue
+			}
+			ip := net.ParseIP(s)
+			ips = append(ips, ip)
+
+			addr := virtualmachine.NewAddress(ip, i, "")
+			addr.Interface = nic.Id
+			addr.MAC = nic.Macaddress
+			addr.Network = nic.Networkname
+			addrs = append(addrs, addr)
 		}
 	}
 
 	vm.ips = ips
+	vm.addrs = addrs
 	vm.KeypairName = listVM.VirtualMachines[0].Keypair
 	vm.state = listVM.VirtualMachines[0].State
 
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/apcera/libretto/cloudinit"
 	"github.com/apcera/libretto/ssh"
 	"github.com/apcera/libretto/util"
 	"github.com/apcera/libretto/virtualmachine"
@@ -18,21 +19,23 @@ import (
 type VM struct {
 	Config Config // Exoscale client configuration
 
-	Name            string          // virtual machine name
-	Template        Template        // template identification
-	ServiceOffering ServiceOffering // Service offering
-	SecurityGroups  []SecurityGroup // list of security groups associated with the virtual machine
-	KeypairName     string          // SSH Keypair identifier to use
-	Userdata        string          // User data sent to the virutal machine
-	Zone            Zone            // Zone identifier
+	Name            string            // virtual machine name
+	Template        Template          // template identification
+	ServiceOffering ServiceOffering   // Service offering
+	SecurityGroups  []SecurityGroup   // list of security groups associated with the virtual machine
+	KeypairName     string            // SSH Keypair identifier to use
+	Userdata        string            // User data sent to the virutal machine
+	CloudConfig     *cloudinit.Config // cloud config sent as user data, can't be combined with Userdata
+	Zone            Zone              // Zone identifier
 
 	ID    string // Virtual machine ID.
 	JobID string // virtual machine creation job ID
 
 	SSHCreds ssh.Credentials // SSH credentials required to connect to machine
 
-	ips   []net.IP // IP addresses
-	state string   // machine state
+	ips   []net.IP                 // IP addresses
+	addrs []virtualmachine.Address // IP addresses with their NIC and scope
+	state string                   // machine state
 }
 
 // Config is the new droplet payload
@@ -86,7 +89,10 @@ const (
 const SSHTimeout = 30 * time.Second
 
 // Compiler will complain if aws.VM doesn't implement VirtualMachine interface.
-var _ virtualmachine.VirtualMachine = (*VM)(nil)
+var (
+	_ virtualmachine.VirtualMachine = (*VM)(nil)
+	_ virtualmachine.AddressLister  = (*VM)(nil)
+)
 
 // GetName returns the name of the virtual machine
 // If an error occurs, an empty string is returned
@@ -133,12 +139,17 @@ func (vm *VM) Provision() error {
 		securityGroups[i] = vm.SecurityGroups[i].ID
 	}
 
+	userdata, err := cloudinit.UserData(vm.Userdata, vm.CloudConfig)
+	if err != nil {
+		return err
+	}
+
 	profile := egoscale.MachineProfile{
 		Template:        vm.Template.ID,
 		ServiceOffering: vm.ServiceOffering.ID,
 		SecurityGroups:  securityGroups,
 		Keypair:         vm.KeypairName,
-		Userdata:        vm.Userdata,
+		Userdata:        userdata,
 		Zone:            vm.Zone.ID,
 		Name:            vm.Name,
 	}
@@ -164,6 +175,16 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	return vm.ips, nil
 }
 
+// GetAddresses returns the addresses of every NIC of the VM
+func (vm *VM) GetAddresses() ([]virtualmachine.Address, error) {
+
+	if err := vm.updateInfo(); err != nil {
+		return nil, err
+	}
+
+	return vm.addrs, nil
+}
+
 // Destroy removes virtual machine and all storage associated
 func (vm *VM) Destroy() error {
 
@@ -21,9 +21,14 @@ type VM struct {
 	MockGetName   func() string
 	MockGetState  func() (string, error)
 	MockProvision func() error
+
+	MockGetAddresses func() ([]lvm.Address, error)
 }
 
-var _ lvm.VirtualMachine = (*VM)(nil)
+var (
+	_ lvm.VirtualMachine = (*VM)(nil)
+	_ lvm.AddressLister  = (*VM)(nil)
+)
 
 // GetName returns the name of the virtual machine
 func (vm *VM) GetName() string {
@@ -89,6 +94,14 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	return []net.IP{}, nil
 }
 
+// GetAddresses returns the mocked list of addresses of the vm.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
+	if vm.MockGetAddresses != nil {
+		return vm.MockGetAddresses()
+	}
+	return []lvm.Address{}, nil
+}
+
 // GetState gets the power state of the VM through VMware tools.
 func (vm *VM) GetState() (string, error) {
 	if vm.MockGetState != nil {
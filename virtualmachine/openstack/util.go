@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"regexp"
@@ -355,6 +356,41 @@ func getServer(vm *VM) (*servers.Server, error) {
 	return status, nil
 }
 
+// serverAddresses returns the addresses the server has on the given network.
+// Fixed IPs are private.
+func serverAddresses(server *servers.Server, networkName string, nic int) []lvm.Address {
+	addrs := []lvm.Address{}
+	addressSlice, ok := server.Addresses[networkName].([]interface{})
+	if !ok {
+		return addrs
+	}
+
+	for _, addressElement := range addressSlice {
+		addressBlock, ok := addressElement.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		addr, _ := addressBlock["addr"].(string)
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+
+		var scope string
+		switch addressBlock["OS-EXT-IPS:type"] {
+		case "floating":
+			scope = lvm.ScopeFloating
+		case "fixed":
+			scope = lvm.ScopePrivate
+		}
+		a := lvm.NewAddress(ip, nic, scope)
+		a.MAC, _ = addressBlock["OS-EXT-IPS-MAC:mac_addr"].(string)
+		a.Network = networkName
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
 // Finds the image endpoint in the given openstack Region. Region is passed within gophercloud.EndpointOpts
 func findImageEndpoint(client *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (string, error) {
 	eo.ApplyDefaults("image")
@@ -8,6 +8,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/apcera/libretto/cloudinit"
 	"github.com/apcera/libretto/ssh"
 	"github.com/apcera/libretto/util"
 	lvm "github.com/apcera/libretto/virtualmachine"
@@ -20,7 +21,10 @@ import (
 )
 
 // Compiler will complain if openstack.VM doesn't implement VirtualMachine interface.
-var _ lvm.VirtualMachine = (*VM)(nil)
+var (
+	_ lvm.VirtualMachine = (*VM)(nil)
+	_ lvm.AddressLister  = (*VM)(nil)
+)
 
 var (
 	// ErrAuthOptions is returned if the credentials are not set properly as a environment variable
@@ -160,6 +164,9 @@ type VM struct {
 	// Credentials are the credentials to use when connecting to the VM over SSH
 	Credentials ssh.Credentials
 
+	// CloudConfig is passed to the VM as user data when set.
+	CloudConfig *cloudinit.Config
+
 	// computeClient represents the client to access to gophercloud compute api. It is set within Provision
 	// and set to nil in destroy.
 	computeClient *gophercloud.ServiceClient
@@ -224,6 +231,13 @@ func (vm *VM) Provision() error {
 		SecurityGroups: []string{securityGroup},
 	}
 
+	if vm.CloudConfig != nil {
+		createOpts.UserData, err = vm.CloudConfig.Render()
+		if err != nil {
+			return err
+		}
+	}
+
 	server, err := servers.Create(client, createOpts).Extract()
 
 	if err != nil {
@@ -316,6 +330,33 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	return ips, nil
 }
 
+// GetAddresses returns the fixed and floating addresses of the VM on each of
+// its networks. The NIC index is the position of the network in vm.Networks.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
+	server, err := getServer(vm)
+	if server == nil {
+		// Probably need to call Provision first.
+		return nil, err
+	}
+
+	client, err := getNetworkClient(vm)
+	if client == nil || err != nil {
+		// Probably need to create some network first.
+		return nil, err
+	}
+
+	addrs := []lvm.Address{}
+	for i, networkID := range vm.Networks {
+		network, err := networks.Get(client, networkID).Extract()
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, serverAddresses(server, network.Name, i)...)
+	}
+
+	return addrs, nil
+}
+
 // Destroy terminates the VM on Openstack. It returns an error if there is no instance ID.
 func (vm *VM) Destroy() error {
 
@@ -380,15 +421,16 @@ func (vm *VM) Destroy() error {
 	return nil
 }
 
-// GetSSH returns an SSH client that can be used to connect to a VM. An error is
-// returned if the VM has no IPs.
+// GetSSH returns an SSH client that can be used to connect to a VM. The
+// address is picked by options.Target, which prefers the floating IP by
+// default. An error is returned if the VM has no IPs.
 func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 	ips, err := util.GetVMIPs(vm, options)
 	if err != nil {
 		return nil, err
 	}
 
-	client := ssh.SSHClient{Creds: &vm.Credentials, IP: ips[PublicIP], Port: 22, Options: options}
+	client := ssh.SSHClient{Creds: &vm.Credentials, IP: ips[0], Port: 22, Options: options}
 	return &client, nil
 }
 
@@ -0,0 +1,143 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package virtualbox
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"syscall"
+
+	"github.com/apcera/libretto/guest"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+var _ lvm.GuestOpener = (*VM)(nil)
+
+// runLevelRegexp parses the run level of the Guest Additions from
+// `showvminfo --machinereadable`. 0 means they are not running.
+var runLevelRegexp = regexp.MustCompile(`(?m)^GuestAdditionsRunLevel=(\d+)`)
+
+// guestClient implements guest.Client with `VBoxManage guestcontrol`.
+type guestClient struct {
+	vm    *VM
+	creds guest.Credentials
+}
+
+// GetGuest returns a client that runs commands and copies files in the VM
+// through the Guest Additions, as the given guest user.
+func (vm *VM) GetGuest(creds guest.Credentials) (guest.Client, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+	return &guestClient{vm: vm, creds: creds}, nil
+}
+
+// args returns the arguments of a guestcontrol subcommand, with the guest
+// credentials.
+func (g *guestClient) args(command string, args ...string) []string {
+	return append([]string{"guestcontrol", g.vm.Name, command,
+		"--username", g.creds.Username, "--password", g.creds.Password}, args...)
+}
+
+// checkAdditions returns guest.ErrToolsNotRunning unless the Guest Additions
+// are running in the guest.
+func (g *guestClient) checkAdditions() error {
+	stdout, err := runner.RunCombinedError("showvminfo", g.vm.Name, "--machinereadable")
+	if err != nil {
+		return lvm.WrapErrors(lvm.ErrVMInfoFailed, err)
+	}
+	match := runLevelRegexp.FindStringSubmatch(stdout)
+	if match == nil || match[1] == "0" {
+		return guest.ErrToolsNotRunning
+	}
+	return nil
+}
+
+// Run runs command with /bin/sh in the guest and waits for it to exit.
+func (g *guestClient) Run(command string, stdout io.Writer, stderr io.Writer) error {
+	if err := g.checkAdditions(); err != nil {
+		return err
+	}
+
+	out, errOut, err := runner.Run(g.args("run", "--exe", "/bin/sh", "--wait-stdout", "--wait-stderr",
+		"--", "sh", "-c", command)...)
+	if stdout != nil {
+		if _, werr := io.WriteString(stdout, out); werr != nil {
+			return werr
+		}
+	}
+	if stderr != nil {
+		if _, werr := io.WriteString(stderr, errOut); werr != nil {
+			return werr
+		}
+	}
+	if err != nil {
+		// VBoxManage exits with the status of the guest program.
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				return &guest.ExitError{Command: command, Status: ws.ExitStatus()}
+			}
+		}
+		return fmt.Errorf("Failed to run the program in the guest: %s", err)
+	}
+	return nil
+}
+
+// Upload copies src to dst in the guest through a temporary file on the host.
+func (g *guestClient) Upload(src io.Reader, dst string, mode uint32) error {
+	if err := g.checkAdditions(); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "libretto-guest")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := runner.RunCombinedError(g.args("copyto", f.Name(), dst)...); err != nil {
+		return fmt.Errorf("Failed to copy %s to the guest: %s", dst, err)
+	}
+	_, err = runner.RunCombinedError(g.args("run", "--exe", "/bin/chmod", "--wait-stdout",
+		"--", "chmod", fmt.Sprintf("%o", mode), dst)...)
+	if err != nil {
+		return fmt.Errorf("Failed to set the mode of %s: %s", dst, err)
+	}
+	return nil
+}
+
+// Download copies src from the guest to dst and closes dst.
+func (g *guestClient) Download(dst io.WriteCloser, src string) error {
+	defer dst.Close()
+	if err := g.checkAdditions(); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "libretto-guest")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if _, err := runner.RunCombinedError(g.args("copyfrom", src, f.Name())...); err != nil {
+		return fmt.Errorf("Failed to copy %s from the guest: %s", src, err)
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(b)
+	return err
+}
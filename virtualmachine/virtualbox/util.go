@@ -5,13 +5,23 @@ package virtualbox
 import (
 	"fmt"
 	"net"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/apcera/libretto/cloudinit"
 	lvm "github.com/apcera/libretto/virtualmachine"
 )
 
+const (
+	// seedController is the storage controller ISO images are attached to.
+	seedController = "Libretto Seed"
+	// seedISOName is the file name of the NoCloud seed ISO in the VM folder.
+	seedISOName = "seed.iso"
+)
+
 type ifKeyValue struct {
 	k, v string
 }
@@ -126,9 +136,78 @@ func (vm *VM) configure() error {
 			return err
 		}
 	}
+
+	if vm.CloudConfig != nil || vm.NetworkConfig != nil {
+		return vm.attachSeed()
+	}
 	return nil
 }
 
+// attachSeed writes the VM's NoCloud seed ISO to the VM's folder and attaches
+// it.
+func (vm *VM) attachSeed() error {
+	seed, err := cloudinit.NewSeed(vm.CloudConfig, vm.NetworkConfig, vm.Name, "")
+	if err != nil {
+		return err
+	}
+	dir, err := vm.folder()
+	if err != nil {
+		return err
+	}
+	iso := filepath.Join(dir, seedISOName)
+	if err := seed.WriteISO(iso); err != nil {
+		return fmt.Errorf("Failed to write the seed ISO: %s", err)
+	}
+	return vm.AttachISO(iso)
+}
+
+// folder returns the directory holding the VM's settings file.
+func (vm *VM) folder() (string, error) {
+	stdout, err := runner.RunCombinedError("showvminfo", vm.Name, "--machinereadable")
+	if err != nil {
+		return "", lvm.WrapErrors(lvm.ErrVMInfoFailed, err)
+	}
+	match := cfgFileRegexp.FindStringSubmatch(stdout)
+	if match == nil {
+		return "", fmt.Errorf("Failed to find the settings file of VM %s", vm.Name)
+	}
+	return filepath.Dir(match[1]), nil
+}
+
+// AttachISO attaches the ISO image at path to the VM as a DVD drive. The drive
+// sits on a storage controller of its own so the image's disk layout is left
+// alone, and is reused when an image was attached before, which the new one
+// replaces. The VM must be powered off.
+func (vm *VM) AttachISO(path string) error {
+	stdout, err := runner.RunCombinedError("showvminfo", vm.Name, "--machinereadable")
+	if err != nil {
+		return lvm.WrapErrors(lvm.ErrVMInfoFailed, err)
+	}
+	if !hasStorageController(stdout, seedController) {
+		_, err = runner.RunCombinedError("storagectl", vm.Name, "--name", seedController, "--add", "sata", "--portcount", "1")
+		if err != nil {
+			return fmt.Errorf("Failed to add the %s storage controller: %s", seedController, err)
+		}
+	}
+	_, err = runner.RunCombinedError("storageattach", vm.Name, "--storagectl", seedController,
+		"--port", "0", "--device", "0", "--type", "dvddrive", "--medium", path)
+	if err != nil {
+		return fmt.Errorf("Failed to attach %s: %s", path, err)
+	}
+	return nil
+}
+
+// hasStorageController reports whether the machine readable VM info lists a
+// storage controller called name.
+func hasStorageController(info, name string) bool {
+	for _, match := range storageControllerRegexp.FindAllStringSubmatch(info, -1) {
+		if match[1] == name {
+			return true
+		}
+	}
+	return false
+}
+
 // This function makes a single request to get IPs from a VM.
 func (vm *VM) requestIPs() []net.IP {
 	if vm.ipUpdate == nil {
@@ -154,6 +233,26 @@ func (vm *VM) requestIPs() []net.IP {
 	return ips
 }
 
+// parseGuestAddresses parses the output of `guestproperty enumerate` into the
+// addresses of every guest network interface.
+func parseGuestAddresses(out string) []lvm.Address {
+	addrs := []lvm.Address{}
+	for _, line := range strings.Split(out, "\n") {
+		match := guestIPRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		idx, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(strings.TrimSpace(match[2])); ip != nil {
+			addrs = append(addrs, lvm.NewAddress(ip, idx, ""))
+		}
+	}
+	return addrs
+}
+
 func (vm *VM) waitUntilReady() error {
 	// Check if the vm already has ips before starting the vm
 	// If it does then wait until the timestamp for at least one of them changes.
@@ -15,6 +15,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/apcera/libretto/cloudinit"
 	"github.com/apcera/libretto/util"
 	"github.com/apcera/util/uuid"
 
@@ -57,15 +58,18 @@ var runner Runner = vboxRunner{}
 
 // Regexp for parsing vboxmanage output.
 var (
-	ipLineRegexp    = regexp.MustCompile(`/VirtualBox/GuestInfo/Net/0/V4/IP`)
-	ipAddrRegexp    = regexp.MustCompile(`value: .*, timestamp`)
-	timestampRegexp = regexp.MustCompile(`timestamp: \d*`)
-	networkRegexp   = regexp.MustCompile(`(?s)Name:.*?VBoxNetworkName`)
-	stateRegexp     = regexp.MustCompile(`^State:`)
-	runningRegexp   = regexp.MustCompile(`running`)
-	backingRegexp   = regexp.MustCompile(`Attachment: NAT`)
-	disabledRegexp  = regexp.MustCompile(`disabled$`)
-	nicRegexp       = regexp.MustCompile(`^NIC \d\d?:`)
+	ipLineRegexp            = regexp.MustCompile(`/VirtualBox/GuestInfo/Net/0/V4/IP`)
+	ipAddrRegexp            = regexp.MustCompile(`value: .*, timestamp`)
+	timestampRegexp         = regexp.MustCompile(`timestamp: \d*`)
+	networkRegexp           = regexp.MustCompile(`(?s)Name:.*?VBoxNetworkName`)
+	stateRegexp             = regexp.MustCompile(`^State:`)
+	runningRegexp           = regexp.MustCompile(`running`)
+	backingRegexp           = regexp.MustCompile(`Attachment: NAT`)
+	disabledRegexp          = regexp.MustCompile(`disabled$`)
+	nicRegexp               = regexp.MustCompile(`^NIC \d\d?:`)
+	guestIPRegexp           = regexp.MustCompile(`/VirtualBox/GuestInfo/Net/(\d+)/V[46]/IP, value: ([^,]*),`)
+	cfgFileRegexp           = regexp.MustCompile(`(?m)^CfgFile="(.*)"\r?$`)
+	storageControllerRegexp = regexp.MustCompile(`(?m)^storagecontrollername\d+="(.*)"\r?$`)
 )
 
 // Backing information for VirtualBox network cards
@@ -76,6 +80,11 @@ const (
 	Disabled
 )
 
+var (
+	_ lvm.VirtualMachine = (*VM)(nil)
+	_ lvm.AddressLister  = (*VM)(nil)
+)
+
 // VM represents a VirtualBox VM
 type VM struct {
 	Src         string
@@ -84,6 +93,10 @@ type VM struct {
 	Name        string
 	Config      Config
 	ipUpdate    map[string]string
+	// CloudConfig and NetworkConfig are written to a NoCloud seed ISO that is
+	// attached to the VM before its first boot, when either is set.
+	CloudConfig   *cloudinit.Config
+	NetworkConfig *cloudinit.NetworkConfig
 }
 
 // GetName returns the name of the virtual machine
@@ -158,11 +171,33 @@ func (vm *VM) Resume() error {
 	return vm.Start()
 }
 
-// GetIPs returns a list of ip addresses associated with the vm through VBox Guest Additions.
+// GetIPs returns a list of ip addresses associated with the vm through VBox
+// Guest Additions. It does not start the VM or wait for it to get an address.
 func (vm *VM) GetIPs() ([]net.IP, error) {
-	vm.waitUntilReady()
+	addrs, err := vm.GetAddresses()
+	if err != nil {
+		return nil, err
+	}
+	return lvm.AddressIPs(addrs), nil
+}
 
-	return vm.ips, nil
+// GetAddresses returns the addresses the Guest Additions currently report for
+// every network card of the VM. Guest properties outlive the VM's power state,
+// so a VM that is not running has no addresses.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
+	state, err := vm.GetState()
+	if err != nil {
+		return nil, err
+	}
+	if state != lvm.VMRunning {
+		return []lvm.Address{}, nil
+	}
+
+	stdout, err := runner.RunCombinedError("guestproperty", "enumerate", vm.Name)
+	if err != nil {
+		return nil, lvm.WrapErrors(lvm.ErrVMInfoFailed, err)
+	}
+	return parseGuestAddresses(stdout), nil
 }
 
 // GetState gets the power state of the VM being serviced by this driver.
@@ -7,6 +7,7 @@ import (
 	"net"
 	"strings"
 
+	"github.com/apcera/libretto/guest"
 	"github.com/apcera/libretto/ssh"
 )
 
@@ -24,6 +25,12 @@ type VirtualMachine interface {
 	GetSSH(ssh.Options) (ssh.Client, error)
 }
 
+// GuestOpener is implemented by VMs whose hypervisor can run commands and copy
+// files in the guest through its guest tools, without network access.
+type GuestOpener interface {
+	GetGuest(guest.Credentials) (guest.Client, error)
+}
+
 const (
 	// VMStarting is the state to use when the VM is starting
 	VMStarting = "starting"
@@ -0,0 +1,200 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package vmrun
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apcera/libretto/guest"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+var _ lvm.GuestOpener = (*VM)(nil)
+
+// exitCodeRegexp parses the exit code vmrun reports for a failed guest
+// program.
+var exitCodeRegexp = regexp.MustCompile(`exit code: (\d+)`)
+
+// timeoutRunner is implemented by runners that can run a command with a
+// timeout other than the one of every vmrun command.
+type timeoutRunner interface {
+	RunWithTimeout(timeout time.Duration, args ...string) (string, string, error)
+}
+
+// guestClient implements guest.Client with the vmrun guest commands.
+type guestClient struct {
+	vm    *VM
+	creds guest.Credentials
+}
+
+// GetGuest returns a client that runs commands and copies files in the VM
+// through VMware Tools, as the given guest user. Copying files is subject to
+// the same timeout as other vmrun commands, while programs run in the guest
+// are only limited by VM.GuestTimeout.
+func (vm *VM) GetGuest(creds guest.Credentials) (guest.Client, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+	return &guestClient{vm: vm, creds: creds}, nil
+}
+
+// args returns the vmrun arguments of a guest command against the VM with the
+// guest credentials.
+func (g *guestClient) args(command string, args ...string) []string {
+	_, vmxFileName := filepath.Split(g.vm.Src)
+	g.vm.VmxFilePath = fmt.Sprintf("%s/%s", g.vm.Dst, vmxFileName)
+
+	return append([]string{"-gu", g.creds.Username, "-gp", g.creds.Password, command, g.vm.VmxFilePath}, args...)
+}
+
+// run runs a vmrun guest command against the VM.
+func (g *guestClient) run(command string, args ...string) (string, error) {
+	return runner.RunCombinedError(g.args(command, args...)...)
+}
+
+// runProgram runs a program in the guest with VM.GuestTimeout rather than the
+// vmrun timeout, when the runner supports it. It returns vmrun's stdout, which
+// carries the exit code of a failed program, and the combined error.
+func (g *guestClient) runProgram(args ...string) (string, error) {
+	tr, ok := runner.(timeoutRunner)
+	if !ok {
+		return g.run("runProgramInGuest", args...)
+	}
+
+	stdout, stderr, err := tr.RunWithTimeout(g.vm.GuestTimeout, g.args("runProgramInGuest", args...)...)
+	if err != nil && stderr != "" {
+		err = fmt.Errorf("%s: %s", err, stderr)
+	}
+	return stdout, err
+}
+
+// checkTools returns guest.ErrToolsNotRunning unless VMware Tools is running
+// in the guest.
+func (g *guestClient) checkTools() error {
+	stdout, err := g.run("checkToolsState")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(stdout) != "running" {
+		return guest.ErrToolsNotRunning
+	}
+	return nil
+}
+
+// Run runs command with /bin/sh in the guest. The output is redirected to
+// temporary files in the guest, which are copied back once it exits.
+func (g *guestClient) Run(command string, stdout io.Writer, stderr io.Writer) error {
+	if err := g.checkTools(); err != nil {
+		return err
+	}
+
+	outPath, err := g.tempFile()
+	if err != nil {
+		return err
+	}
+	defer g.run("deleteFileInGuest", outPath)
+	errPath, err := g.tempFile()
+	if err != nil {
+		return err
+	}
+	defer g.run("deleteFileInGuest", errPath)
+
+	out, runErr := g.runProgram("/bin/sh", "-c", guest.Quote(guest.Redirect(command, outPath, errPath)))
+	status := 0
+	if runErr != nil {
+		// vmrun reports the exit code of the program on stdout.
+		match := exitCodeRegexp.FindStringSubmatch(out)
+		if match == nil {
+			return fmt.Errorf("Failed to run the program in the guest: %s", lvm.WrapErrors(runErr, errors.New(out)))
+		}
+		status, _ = strconv.Atoi(match[1])
+	}
+
+	if stdout != nil {
+		if err := g.download(stdout, outPath); err != nil {
+			return err
+		}
+	}
+	if stderr != nil {
+		if err := g.download(stderr, errPath); err != nil {
+			return err
+		}
+	}
+	if status != 0 {
+		return &guest.ExitError{Command: command, Status: status}
+	}
+	return nil
+}
+
+func (g *guestClient) tempFile() (string, error) {
+	stdout, err := g.run("createTempfileInGuest")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create a temporary file in the guest: %s", err)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// Upload copies src to dst in the guest through a temporary file on the host.
+func (g *guestClient) Upload(src io.Reader, dst string, mode uint32) error {
+	if err := g.checkTools(); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "libretto-guest")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := g.run("copyFileFromHostToGuest", f.Name(), dst); err != nil {
+		return fmt.Errorf("Failed to copy %s to the guest: %s", dst, err)
+	}
+	if _, err := g.runProgram("/bin/chmod", fmt.Sprintf("%o", mode), dst); err != nil {
+		return fmt.Errorf("Failed to set the mode of %s: %s", dst, err)
+	}
+	return nil
+}
+
+// Download copies src from the guest to dst and closes dst.
+func (g *guestClient) Download(dst io.WriteCloser, src string) error {
+	defer dst.Close()
+	if err := g.checkTools(); err != nil {
+		return err
+	}
+	return g.download(dst, src)
+}
+
+func (g *guestClient) download(w io.Writer, src string) error {
+	f, err := ioutil.TempFile("", "libretto-guest")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if _, err := g.run("copyFileFromGuestToHost", src, f.Name()); err != nil {
+		return fmt.Errorf("Failed to copy %s from the guest: %s", src, err)
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
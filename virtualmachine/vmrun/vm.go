@@ -18,6 +18,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/apcera/libretto/cloudinit"
 	libssh "github.com/apcera/libretto/ssh"
 	"github.com/apcera/libretto/util"
 	lvm "github.com/apcera/libretto/virtualmachine"
@@ -43,11 +44,33 @@ const vmrunTimeout = 90 * time.Second
 // ErrVmrunTimeout is returned when vmrun doesn't finish executing in `vmrunTimeout` seconds.
 var ErrVmrunTimeout = errors.New("Timed out waiting for vmrun")
 
-// Regular expression to parse the VMX file
-var ethernetRegexp = regexp.MustCompile(`ethernet.*\n`)
+// cdromFormat attaches an ISO image as a CD-ROM on the secondary IDE channel.
+const cdromFormat = `ide1:0.present = "TRUE"
+ide1:0.deviceType = "cdrom-image"
+ide1:0.fileName = "%s"
+ide1:0.startConnected = "TRUE"
+`
+
+// noGuestIPMessage is printed by vmrun when VMware tools hasn't reported an
+// address for the guest.
+const noGuestIPMessage = "Unable to get the IP address"
+
+// seedISOName is the file name of the NoCloud seed ISO in the VM folder.
+const seedISOName = "seed.iso"
+
+// Regular expressions to parse the VMX file
+var (
+	ethernetRegexp = regexp.MustCompile(`ethernet.*\n`)
+	ide10Regexp    = regexp.MustCompile(`(?i)ide1:0\..*\n`)
+)
 
 var runner Runner = vmrunRunner{}
 
+var (
+	_ lvm.VirtualMachine = (*VM)(nil)
+	_ lvm.AddressLister  = (*VM)(nil)
+)
+
 // Backing is the network card backing type for VMware virtual machines.
 type Backing int
 
@@ -75,6 +98,13 @@ type vmrunRunner struct {
 
 // Run runs a vmrun command.
 func (f vmrunRunner) Run(args ...string) (string, string, error) {
+	return f.RunWithTimeout(vmrunTimeout, args...)
+}
+
+// RunWithTimeout runs a vmrun command and kills it if it doesn't finish
+// within timeout. A zero timeout lets the command run for as long as it
+// takes.
+func (f vmrunRunner) RunWithTimeout(timeout time.Duration, args ...string) (string, string, error) {
 	var vmrunPath string
 
 	// If vmrun is not found in the system path, fall back to the
@@ -96,12 +126,17 @@ func (f vmrunRunner) Run(args ...string) (string, string, error) {
 	cmd.Stdout, cmd.Stderr = &stdout, &stderr
 
 	err = cmd.Start()
-	timer := time.AfterFunc(vmrunTimeout, func() {
-		cmd.Process.Kill()
-		err = ErrVmrunTimeout
-	})
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			cmd.Process.Kill()
+			err = ErrVmrunTimeout
+		})
+	}
 	e := cmd.Wait()
-	timer.Stop()
+	if timer != nil {
+		timer.Stop()
+	}
 
 	if err != nil || e != nil {
 		err = lvm.WrapErrors(err, e)
@@ -132,6 +167,13 @@ type VM struct {
 	ips         []net.IP
 	Credentials libssh.Credentials
 	Config      Config
+	// CloudConfig and NetworkConfig are written to a NoCloud seed ISO in Dst
+	// and attached to the VM before its first boot, when either is set.
+	CloudConfig   *cloudinit.Config
+	NetworkConfig *cloudinit.NetworkConfig
+	// GuestTimeout limits how long a program run through the guest client
+	// can take. Zero lets it run for as long as it takes.
+	GuestTimeout time.Duration
 }
 
 var backingList = []string{"nat", "bridged"}
@@ -229,11 +271,49 @@ func (vm *VM) Start() error {
 	return nil
 }
 
-// GetIPs returns a list of ip addresses associated with the vm through VMware tools
+// GetIPs returns a list of ip addresses associated with the vm through VMware
+// tools. It does not start the VM or wait for it to get an address.
 func (vm *VM) GetIPs() ([]net.IP, error) {
-	vm.waitUntilReady()
+	addrs, err := vm.GetAddresses()
+	if err != nil {
+		return nil, err
+	}
+	return lvm.AddressIPs(addrs), nil
+}
 
-	return vm.ips, nil
+// GetAddresses returns the address VMware tools currently reports for the
+// VM. vmrun only knows about the primary address of a running VM, so at most
+// one address is returned and a VM that is not running has none.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
+	src := vm.Src
+	dst := vm.Dst
+
+	_, vmxFileName := filepath.Split(src)
+	vm.VmxFilePath = fmt.Sprintf("%s/%s", dst, vmxFileName)
+
+	state, err := vm.GetState()
+	if err != nil {
+		return nil, err
+	}
+	if state != lvm.VMRunning {
+		return []lvm.Address{}, nil
+	}
+
+	// Without the wait flag vmrun fails right away when tools hasn't reported
+	// an address yet, which just means there is none to return.
+	stdout, err := runner.RunCombinedError("getGuestIPAddress", vm.VmxFilePath)
+	if err != nil {
+		if strings.Contains(stdout, noGuestIPMessage) {
+			return []lvm.Address{}, nil
+		}
+		return nil, lvm.WrapErrors(lvm.ErrVMInfoFailed, err, errors.New(stdout))
+	}
+
+	addrs := []lvm.Address{}
+	if ip := net.ParseIP(strings.TrimSpace(stdout)); ip != nil {
+		addrs = append(addrs, lvm.NewAddress(ip, 0, ""))
+	}
+	return addrs, nil
 }
 
 // GetState gets the power state of the VM through VMware tools.
@@ -358,9 +438,37 @@ func (vm *VM) configure() error {
 		newVmxString += b.String()
 	}
 
+	if vm.CloudConfig != nil || vm.NetworkConfig != nil {
+		if err := vm.writeSeed(); err != nil {
+			return err
+		}
+		newVmxString = ide10Regexp.ReplaceAllString(newVmxString, "") + CDROMEntries(seedISOName)
+	}
+
 	return ioutil.WriteFile(vm.VmxFilePath, []byte(newVmxString), 0755)
 }
 
+// writeSeed writes the VM's NoCloud seed ISO to Dst.
+func (vm *VM) writeSeed() error {
+	name := vm.Name
+	if name == "" {
+		name = filepath.Base(vm.Dst)
+	}
+	seed, err := cloudinit.NewSeed(vm.CloudConfig, vm.NetworkConfig, name, "")
+	if err != nil {
+		return err
+	}
+	return seed.WriteISO(filepath.Join(vm.Dst, seedISOName))
+}
+
+// CDROMEntries returns the VMX entries that attach the ISO image at path as
+// the ide1:0 CD-ROM. A relative path is resolved against the VMX file's
+// directory.
+func CDROMEntries(path string) string {
+	// VMX values escape double quotes as |22.
+	return fmt.Sprintf(cdromFormat, strings.Replace(path, `"`, "|22", -1))
+}
+
 // This function makes a single request to get IPs from a VM.
 func (vm *VM) requestIPs() []net.IP {
 	ips := []net.IP{}
@@ -0,0 +1,155 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Customization configures the guest OS of a VM cloned from a template, so it
+// doesn't come up with the template's identity. Only Linux guests are
+// supported, and the template needs VMware Tools and Perl.
+type Customization struct {
+	// SpecName is the name of a spec saved in the CustomizationSpecManager
+	// to start from. The other fields override it when set.
+	SpecName string
+
+	// Hostname defaults to the VM's name.
+	Hostname string
+	Domain   string
+	// TimeZone is a tz database name, e.g. "America/Los_Angeles".
+	TimeZone string
+	// HwClockLocal sets the hardware clock to local time instead of UTC.
+	HwClockLocal bool
+
+	DNSServers  []string
+	DNSSuffixes []string
+
+	// NICs configures the VM's network cards, in the order of the cards of
+	// the clone. Cards without settings use DHCP.
+	NICs []NICCustomization
+}
+
+// NICCustomization configures the addresses of a network card. The card uses
+// DHCP for IPv4 when IP is empty, and has no IPv6 address unless IPv6 or
+// DHCPv6 is set.
+type NICCustomization struct {
+	IP      string
+	Netmask string
+	Gateway []string
+
+	// IPv6 are addresses in CIDR notation, e.g. "2001:db8::10/64".
+	IPv6        []string
+	IPv6Gateway []string
+	DHCPv6      bool
+}
+
+// getCustomizationSpec retrieves a spec saved in the CustomizationSpecManager.
+var getCustomizationSpec = func(vm *VM, name string) (*types.CustomizationSpec, error) {
+	m := object.NewCustomizationSpecManager(vm.client.Client)
+	item, err := m.GetCustomizationSpec(vm.ctx, name)
+	if err != nil {
+		return nil, NewErrorObjectNotFound(err, name)
+	}
+	return &item.Spec, nil
+}
+
+// customizationSpec returns the spec to customize the VM with, for a VM with
+// nics network cards, or nil if the VM isn't customized.
+func customizationSpec(vm *VM, nics int) (*types.CustomizationSpec, error) {
+	c := vm.Customization
+	if c == nil {
+		return nil, nil
+	}
+
+	spec := &types.CustomizationSpec{}
+	if c.SpecName != "" {
+		var err error
+		if spec, err = getCustomizationSpec(vm, c.SpecName); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.Identity == nil {
+		spec.Identity = &types.CustomizationLinuxPrep{}
+	}
+	// A saved spec for another guest OS is used as is.
+	if prep, ok := spec.Identity.(*types.CustomizationLinuxPrep); ok {
+		if c.Hostname != "" {
+			prep.HostName = &types.CustomizationFixedName{Name: c.Hostname}
+		} else if prep.HostName == nil {
+			prep.HostName = &types.CustomizationFixedName{Name: vm.Name}
+		}
+		if c.Domain != "" {
+			prep.Domain = c.Domain
+		}
+		if c.TimeZone != "" {
+			prep.TimeZone = c.TimeZone
+		}
+		if prep.HwClockUTC == nil || c.HwClockLocal {
+			prep.HwClockUTC = types.NewBool(!c.HwClockLocal)
+		}
+	}
+
+	if len(c.DNSServers) > 0 {
+		spec.GlobalIPSettings.DnsServerList = c.DNSServers
+	}
+	if len(c.DNSSuffixes) > 0 {
+		spec.GlobalIPSettings.DnsSuffixList = c.DNSSuffixes
+	}
+
+	if len(c.NICs) > nics {
+		return nil, fmt.Errorf("customization has settings for %d network cards, the template has %d", len(c.NICs), nics)
+	}
+	if len(c.NICs) > 0 || len(spec.NicSettingMap) == 0 {
+		spec.NicSettingMap = make([]types.CustomizationAdapterMapping, nics)
+		for i := range spec.NicSettingMap {
+			var nic NICCustomization
+			if i < len(c.NICs) {
+				nic = c.NICs[i]
+			}
+			adapter, err := nic.ipSettings()
+			if err != nil {
+				return nil, err
+			}
+			spec.NicSettingMap[i].Adapter = adapter
+		}
+	}
+	return spec, nil
+}
+
+func (nic NICCustomization) ipSettings() (types.CustomizationIPSettings, error) {
+	s := types.CustomizationIPSettings{Ip: &types.CustomizationDhcpIpGenerator{}}
+	if nic.IP != "" {
+		if net.ParseIP(nic.IP).To4() == nil {
+			return s, fmt.Errorf("invalid IPv4 address: %q", nic.IP)
+		}
+		s.Ip = &types.CustomizationFixedIp{IpAddress: nic.IP}
+		s.SubnetMask = nic.Netmask
+		s.Gateway = nic.Gateway
+	}
+
+	if len(nic.IPv6) == 0 && !nic.DHCPv6 {
+		return s, nil
+	}
+	s.IpV6Spec = &types.CustomizationIPSettingsIpV6AddressSpec{Gateway: nic.IPv6Gateway}
+	if nic.DHCPv6 {
+		s.IpV6Spec.Ip = append(s.IpV6Spec.Ip, &types.CustomizationDhcpIpV6Generator{})
+	}
+	for _, addr := range nic.IPv6 {
+		ip, ipNet, err := net.ParseCIDR(addr)
+		if err != nil || ip.To4() != nil {
+			return s, fmt.Errorf("invalid IPv6 address: %q", addr)
+		}
+		prefix, _ := ipNet.Mask.Size()
+		s.IpV6Spec.Ip = append(s.IpV6Spec.Ip, &types.CustomizationFixedIpV6{
+			IpAddress:  ip.String(),
+			SubnetMask: prefix,
+		})
+	}
+	return s, nil
+}
@@ -0,0 +1,279 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	// ExportFormatOVF exports the OVF descriptor and the disks as separate
+	// files.
+	ExportFormatOVF = "ovf"
+	// ExportFormatOVA exports the OVF descriptor and the disks as a single
+	// tar file.
+	ExportFormatOVA = "ova"
+)
+
+// Export exports the VM or template named Name into dir, as <Name>.ovf and its
+// disks with ExportFormatOVF or as <Name>.ova with ExportFormatOVA. The VM
+// needs to be powered off.
+func (vm *VM) Export(dir, format string) error {
+	if format != ExportFormatOVF && format != ExportFormatOVA {
+		return fmt.Errorf("unknown export format: %q", format)
+	}
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.cancel()
+
+	if format == ExportFormatOVA {
+		f, err := os.Create(filepath.Join(dir, vm.Name+".ova"))
+		if err != nil {
+			return err
+		}
+		if err = exportOVA(vm, f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	descriptor, _, err := exportFiles(vm, dir)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, vm.Name+".ovf"), []byte(descriptor), 0644)
+}
+
+// ExportOVA writes the VM or template named Name to w as an OVA. The VM needs
+// to be powered off.
+func (vm *VM) ExportOVA(w io.Writer) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.cancel()
+	return exportOVA(vm, w)
+}
+
+// exportOVA downloads the VM's disks into a temporary directory, since the
+// descriptor has to come first in the tar stream and needs their sizes.
+func exportOVA(vm *VM, w io.Writer) error {
+	dir, err := ioutil.TempDir("", "libretto-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	descriptor, files, err := exportFiles(vm, dir)
+	if err != nil {
+		return err
+	}
+	return writeOVA(w, vm.Name+".ovf", descriptor, dir, files)
+}
+
+// writeOVA writes the descriptor and then the files out of dir as a tar
+// stream.
+func writeOVA(w io.Writer, name, descriptor, dir string, files []types.OvfFile) error {
+	tw := tar.NewWriter(w)
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(descriptor)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(tw, descriptor); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    file.Path,
+			Mode:    0644,
+			Size:    file.Size,
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		f, err := open(filepath.Join(dir, file.Path))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// exportFiles downloads the disks of the VM into dir through an export lease
+// and returns the OVF descriptor that references them.
+var exportFiles = func(vm *VM, dir string) (string, []types.OvfFile, error) {
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return "", nil, err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	res, err := methods.ExportVm(vm.ctx, vm.client.Client, &types.ExportVm{This: vmMo.Reference()})
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating an export lease on the vm: %s", err)
+	}
+	nfcLease := object.NewHttpNfcLease(vm.client.Client, res.Returnval)
+	lease := NewLease(vm.ctx, nfcLease)
+	leaseInfo, err := lease.Wait()
+	if err != nil {
+		return "", nil, fmt.Errorf("error waiting on the nfc lease: %s", err)
+	}
+
+	files, err := downloadDisks(vm, dir, leaseInfo, lease)
+	if err != nil {
+		nfcLease.HttpNfcLeaseAbort(vm.ctx, nil)
+		return "", nil, err
+	}
+	if err = lease.Complete(); err != nil {
+		return "", nil, fmt.Errorf("error completing the nfc lease: %s", err)
+	}
+
+	m := object.NewOvfManager(vm.client.Client)
+	vmObj := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	desc, err := m.CreateDescriptor(vm.ctx, vmObj, types.OvfCreateDescriptorParams{
+		Name:     vm.Name,
+		OvfFiles: files,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating the ovf descriptor: %s", err)
+	}
+	if len(desc.Error) > 0 {
+		return "", nil, fmt.Errorf("error creating the ovf descriptor: %s", desc.Error[0].LocalizedMessage)
+	}
+	return desc.OvfDescriptor, files, nil
+}
+
+// downloadDisks downloads the disks of the lease into dir, reporting the
+// progress on the lease.
+func downloadDisks(vm *VM, dir string, leaseInfo *types.HttpNfcLeaseInfo, lease Lease) ([]types.OvfFile, error) {
+	var downloaded int64
+	// The disks are compressed, so the capacity is an upper bound.
+	stop := reportProgress(lease, leaseInfo.TotalDiskCapacityInKB*1024, &downloaded)
+	defer stop()
+
+	var files []types.OvfFile
+	for _, device := range leaseInfo.DeviceUrl {
+		if device.Disk != nil && !*device.Disk {
+			continue
+		}
+		rawURL := device.Url
+		if strings.Contains(rawURL, "*") {
+			rawURL = strings.Replace(rawURL, "*", vm.Host, 1)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, NewErrorParsingURL(rawURL, err)
+		}
+		name := path.Base(u.Path)
+
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		n, err := downloadFile(vm, u.String(), &progressWriter{w: f, n: &downloaded})
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error downloading %s: %s", name, err)
+		}
+		files = append(files, types.OvfFile{
+			DeviceId: device.Key,
+			Path:     name,
+			Size:     n,
+		})
+	}
+	return files, nil
+}
+
+// downloadFile downloads rawURL with the VM's session into w.
+var downloadFile = func(vm *VM, rawURL string, w io.Writer) (int64, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	// The soap client carries the session cookie the lease needs.
+	resp, err := vm.client.Client.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, NewErrorBadResponse(resp)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// reportProgress reports *n out of total bytes as the progress of the lease
+// every few seconds, until stop is called.
+func reportProgress(lease Lease, total int64, n *int64) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(5 * time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				if total > 0 {
+					lease.HTTPNfcLeaseProgress(int(atomic.LoadInt64(n) * 100 / total))
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// progressWriter counts the bytes written through it.
+type progressWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	atomic.AddInt64(p.n, int64(n))
+	return n, err
+}
+
+// progressReader counts the bytes read through it.
+type progressReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	atomic.AddInt64(p.n, int64(n))
+	return n, err
+}
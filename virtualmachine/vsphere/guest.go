@@ -0,0 +1,218 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/apcera/libretto/guest"
+	lvm "github.com/apcera/libretto/virtualmachine"
+	vmguest "github.com/vmware/govmomi/guest"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+var _ lvm.GuestOpener = (*VM)(nil)
+
+// guestClient implements guest.Client with the vSphere guest operations API.
+// Like the VM's other methods, every call sets up its own session, on a copy
+// of the VM's connection settings so that it doesn't replace the session of
+// calls made on the VM at the same time.
+type guestClient struct {
+	vm   *VM
+	auth *types.NamePasswordAuthentication
+}
+
+// GetGuest returns a client that runs commands and copies files in the VM
+// through VMware Tools, as the given guest user.
+func (vm *VM) GetGuest(creds guest.Credentials) (guest.Client, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+	return &guestClient{
+		vm: vm,
+		auth: &types.NamePasswordAuthentication{
+			Username: creds.Username,
+			Password: creds.Password,
+		},
+	}, nil
+}
+
+// withManagers sets up a session and calls f with it and the guest file and
+// process managers of the VM.
+func (g *guestClient) withManagers(f func(*VM, *vmguest.FileManager, *vmguest.ProcessManager) error) error {
+	vm := &VM{
+		Host:       g.vm.Host,
+		Username:   g.vm.Username,
+		Password:   g.vm.Password,
+		Insecure:   g.vm.Insecure,
+		Sessions:   g.vm.Sessions,
+		Datacenter: g.vm.Datacenter,
+		Name:       g.vm.Name,
+	}
+	if err := SetupSession(vm); err != nil {
+		return fmt.Errorf("Error setting up vSphere session: %s", err)
+	}
+	defer vm.cancel()
+
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+	if vmMo.Guest == nil || vmMo.Guest.GuestState != "running" {
+		return guest.ErrToolsNotRunning
+	}
+
+	ops := vmguest.NewOperationsManager(vm.client.Client, vmMo.Reference())
+	fm, err := ops.FileManager(vm.ctx)
+	if err != nil {
+		return err
+	}
+	pm, err := ops.ProcessManager(vm.ctx)
+	if err != nil {
+		return err
+	}
+	return f(vm, fm, pm)
+}
+
+// Run runs command with /bin/sh in the guest. The output is redirected to
+// temporary files in the guest, which are copied back once it exits.
+func (g *guestClient) Run(command string, stdout io.Writer, stderr io.Writer) error {
+	return g.withManagers(func(vm *VM, fm *vmguest.FileManager, pm *vmguest.ProcessManager) error {
+		dir, err := fm.CreateTemporaryDirectory(vm.ctx, g.auth, "libretto", "")
+		if err != nil {
+			return fmt.Errorf("Failed to create a temporary directory in the guest: %s", err)
+		}
+		defer fm.DeleteDirectory(vm.ctx, g.auth, dir, true)
+
+		outPath, errPath := path.Join(dir, "stdout"), path.Join(dir, "stderr")
+		spec := &types.GuestProgramSpec{
+			ProgramPath: "/bin/sh",
+			Arguments:   "-c " + guest.Quote(guest.Redirect(command, outPath, errPath)),
+		}
+		pid, err := pm.StartProgram(vm.ctx, g.auth, spec)
+		if err != nil {
+			return fmt.Errorf("Failed to start the program in the guest: %s", err)
+		}
+		ctx := vm.ctx
+		if g.vm.GuestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(vm.ctx, g.vm.GuestTimeout)
+			defer cancel()
+		}
+		status, err := g.wait(ctx, pm, pid)
+		if err != nil {
+			pm.TerminateProcess(vm.ctx, g.auth, pid)
+			return err
+		}
+
+		if stdout != nil {
+			if err := g.download(vm, fm, stdout, outPath); err != nil {
+				return err
+			}
+		}
+		if stderr != nil {
+			if err := g.download(vm, fm, stderr, errPath); err != nil {
+				return err
+			}
+		}
+		if status != 0 {
+			return &guest.ExitError{Command: command, Status: status}
+		}
+		return nil
+	})
+}
+
+// wait polls the guest until the process exits and returns its exit code, or
+// until ctx is done.
+func (g *guestClient) wait(ctx context.Context, pm processLister, pid int64) (int, error) {
+	for {
+		procs, err := pm.ListProcesses(ctx, g.auth, []int64{pid})
+		if ctx.Err() != nil {
+			return 0, fmt.Errorf("Stopped waiting for guest process %d: %s", pid, ctx.Err())
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Failed to get the state of guest process %d: %s", pid, err)
+		}
+		if len(procs) == 1 && procs[0].EndTime != nil {
+			return procs[0].ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("Stopped waiting for guest process %d: %s", pid, ctx.Err())
+		case <-time.After(guest.PollInterval):
+		}
+	}
+}
+
+// processLister is the part of the guest process manager wait uses.
+type processLister interface {
+	ListProcesses(context.Context, types.BaseGuestAuthentication, []int64) ([]types.GuestProcessInfo, error)
+}
+
+// Upload copies src to dst in the guest.
+func (g *guestClient) Upload(src io.Reader, dst string, mode uint32) error {
+	b, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	return g.withManagers(func(vm *VM, fm *vmguest.FileManager, _ *vmguest.ProcessManager) error {
+		attr := &types.GuestPosixFileAttributes{Permissions: int64(mode)}
+		rawURL, err := fm.InitiateFileTransferToGuest(vm.ctx, g.auth, dst, attr, int64(len(b)), true)
+		if err != nil {
+			return fmt.Errorf("Failed to start the transfer to %s: %s", dst, err)
+		}
+		u, err := vm.client.ParseURL(rawURL)
+		if err != nil {
+			return NewErrorParsingURL(rawURL, err)
+		}
+		p := soap.DefaultUpload
+		p.ContentLength = int64(len(b))
+		return vm.client.Upload(bytes.NewReader(b), u, &p)
+	})
+}
+
+// Download copies src from the guest to dst and closes dst.
+func (g *guestClient) Download(dst io.WriteCloser, src string) error {
+	defer dst.Close()
+	return g.withManagers(func(vm *VM, fm *vmguest.FileManager, _ *vmguest.ProcessManager) error {
+		return g.download(vm, fm, dst, src)
+	})
+}
+
+func (g *guestClient) download(vm *VM, fm *vmguest.FileManager, w io.Writer, src string) error {
+	info, err := fm.InitiateFileTransferFromGuest(vm.ctx, g.auth, src)
+	if err != nil {
+		return fmt.Errorf("Failed to start the transfer from %s: %s", src, err)
+	}
+	u, err := vm.client.ParseURL(info.Url)
+	if err != nil {
+		return NewErrorParsingURL(info.Url, err)
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := vm.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return NewErrorBadResponse(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,203 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"github.com/apcera/libretto/util"
+)
+
+const (
+	// DatastorePolicyRandom picks a random datastore out of VM.Datastores.
+	DatastorePolicyRandom = "random"
+	// DatastorePolicyMostFreeSpace picks the datastore with the most free
+	// space out of VM.Datastores.
+	DatastorePolicyMostFreeSpace = "most_free_space"
+)
+
+// pickDatastore picks a datastore out of names with the VM's datastore
+// policy. Datastores that are inaccessible, in maintenance mode or have less
+// than vm.MinFreeSpaceMB free are skipped.
+var pickDatastore = func(vm *VM, dc *mo.Datacenter, names []string) (string, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var candidates []mo.Datastore
+	for _, dsMor := range dc.Datastore {
+		dsMo := mo.Datastore{}
+		ps := []string{"name", "summary"}
+		err := vm.collector.RetrieveOne(vm.ctx, dsMor, ps, &dsMo)
+		if err != nil {
+			return "", NewErrorPropertyRetrieval(dsMor, ps, err)
+		}
+		if wanted[dsMo.Name] {
+			candidates = append(candidates, dsMo)
+		}
+	}
+	return selectDatastore(vm, candidates)
+}
+
+// selectDatastore picks one of the usable datastores out of candidates with
+// the VM's datastore policy.
+func selectDatastore(vm *VM, candidates []mo.Datastore) (string, error) {
+	var usable []mo.Datastore
+	for _, ds := range candidates {
+		s := ds.Summary
+		if !s.Accessible {
+			continue
+		}
+		if s.MaintenanceMode != "" && s.MaintenanceMode != string(types.DatastoreSummaryMaintenanceModeStateNormal) {
+			continue
+		}
+		if s.FreeSpace < vm.MinFreeSpaceMB*1024*1024 {
+			continue
+		}
+		usable = append(usable, ds)
+	}
+	if len(usable) == 0 {
+		return "", ErrorNoDatastore
+	}
+
+	switch vm.DatastorePolicy {
+	case "", DatastorePolicyRandom:
+		n := util.Random(1, len(usable))
+		return usable[n-1].Name, nil
+	case DatastorePolicyMostFreeSpace:
+		best := usable[0]
+		for _, ds := range usable[1:] {
+			if ds.Summary.FreeSpace > best.Summary.FreeSpace {
+				best = ds
+			}
+		}
+		return best.Name, nil
+	}
+	return "", fmt.Errorf("unknown datastore policy: %q", vm.DatastorePolicy)
+}
+
+// recommendDatastore asks storage DRS for the datastore of the VM's datastore
+// cluster to clone the template to with cisp.
+var recommendDatastore = func(vm *VM, dc *mo.Datacenter, template types.ManagedObjectReference, cisp types.VirtualMachineCloneSpec) (types.ManagedObjectReference, error) {
+	pod, err := findStoragePod(vm, dc.DatastoreFolder, vm.DatastoreCluster)
+	if err != nil {
+		return types.ManagedObjectReference{}, err
+	}
+
+	cisp.Location.Datastore = nil
+	folder := dc.VmFolder
+	srm := object.NewStorageResourceManager(vm.client.Client)
+	result, err := srm.RecommendDatastores(vm.ctx, types.StoragePlacementSpec{
+		Type:             string(types.StoragePlacementSpecPlacementTypeClone),
+		Vm:               &template,
+		CloneName:        vm.Name,
+		CloneSpec:        &cisp,
+		Folder:           &folder,
+		PodSelectionSpec: types.StorageDrsPodSelectionSpec{StoragePod: pod},
+	})
+	if err != nil {
+		return types.ManagedObjectReference{}, fmt.Errorf("error getting storage DRS recommendations: %s", err)
+	}
+	if ds := recommendedDatastore(result); ds != nil {
+		return *ds, nil
+	}
+	return types.ManagedObjectReference{}, NewErrorObjectNotFound(errors.New("storage DRS did not recommend a datastore"), vm.DatastoreCluster)
+}
+
+// recommendedDatastore returns the destination of the first placement in
+// result, or nil.
+func recommendedDatastore(result *types.StoragePlacementResult) *types.ManagedObjectReference {
+	if result == nil {
+		return nil
+	}
+	for _, r := range result.Recommendations {
+		for _, a := range r.Action {
+			if p, ok := a.(*types.StoragePlacementAction); ok {
+				return &p.Destination
+			}
+		}
+	}
+	return nil
+}
+
+// findStoragePod finds the datastore cluster named name under the folder.
+func findStoragePod(vm *VM, folder types.ManagedObjectReference, name string) (*types.ManagedObjectReference, error) {
+	folderMo := mo.Folder{}
+	err := vm.collector.RetrieveOne(vm.ctx, folder, []string{"childEntity"}, &folderMo)
+	if err != nil {
+		return nil, NewErrorPropertyRetrieval(folder, []string{"childEntity"}, err)
+	}
+	for _, child := range folderMo.ChildEntity {
+		switch child.Type {
+		case "StoragePod":
+			podMo := mo.StoragePod{}
+			err := vm.collector.RetrieveOne(vm.ctx, child, []string{"name"}, &podMo)
+			if err != nil {
+				return nil, NewErrorPropertyRetrieval(child, []string{"name"}, err)
+			}
+			if podMo.Name == name {
+				ref := child
+				return &ref, nil
+			}
+		case "Folder":
+			ref, err := findStoragePod(vm, child, name)
+			if err == nil {
+				return ref, nil
+			}
+			if _, ok := err.(ErrorObjectNotFound); !ok {
+				return nil, err
+			}
+		}
+	}
+	return nil, NewErrorObjectNotFound(errors.New("datastore cluster not found"), name)
+}
+
+// leastLoadedHost returns the host out of hosts with the lowest CPU or memory
+// usage, skipping the ones that are disconnected or in maintenance mode.
+var leastLoadedHost = func(vm *VM, hosts []types.ManagedObjectReference) (types.ManagedObjectReference, error) {
+	var best *types.ManagedObjectReference
+	bestLoad := 0.0
+	for i, host := range hosts {
+		hsMo := mo.HostSystem{}
+		ps := []string{"summary"}
+		err := vm.collector.RetrieveOne(vm.ctx, host, ps, &hsMo)
+		if err != nil {
+			return types.ManagedObjectReference{}, NewErrorPropertyRetrieval(host, ps, err)
+		}
+		load, ok := hostLoad(hsMo.Summary)
+		if ok && (best == nil || load < bestLoad) {
+			best, bestLoad = &hosts[i], load
+		}
+	}
+	if best == nil {
+		return types.ManagedObjectReference{}, fmt.Errorf("No suitable hosts found in the cluster")
+	}
+	return *best, nil
+}
+
+// hostLoad returns the highest of the CPU and memory usage of a host, between
+// 0 and 1, and whether VMs can be placed on it.
+func hostLoad(s types.HostListSummary) (float64, bool) {
+	if r := s.Runtime; r != nil {
+		if r.InMaintenanceMode || r.ConnectionState != types.HostSystemConnectionStateConnected {
+			return 0, false
+		}
+	}
+	hw := s.Hardware
+	if hw == nil || hw.CpuMhz == 0 || hw.NumCpuCores == 0 || hw.MemorySize == 0 {
+		// The load of the host is unknown, so it's treated as idle.
+		return 0, true
+	}
+	cpu := float64(s.QuickStats.OverallCpuUsage) / float64(hw.CpuMhz*int(hw.NumCpuCores))
+	mem := float64(s.QuickStats.OverallMemoryUsage) / float64(hw.MemorySize/(1024*1024))
+	if mem > cpu {
+		return mem, true
+	}
+	return cpu, true
+}
@@ -0,0 +1,306 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// defaultKeepAlive is how long a shared session can be idle before a request
+// is sent to keep it alive, when SessionManager.KeepAlive isn't set.
+const defaultKeepAlive = 5 * time.Minute
+
+// SessionManager shares vSphere sessions between VMs, so that they don't log
+// in for every call. Sessions are keyed by host and user, kept alive while
+// they're idle and logged in again if they expire anyway. Set VM.Sessions to
+// use one; the zero value is ready to use.
+type SessionManager struct {
+	// KeepAlive is how long a session can be idle before a request is sent
+	// to keep it alive. Defaults to 5 minutes.
+	KeepAlive time.Duration
+
+	mu       sync.Mutex
+	sessions map[sessionKey]*sharedSession
+	// err is the first error logging out a session once it's released.
+	err error
+}
+
+type sessionKey struct {
+	host     string
+	username string
+	password string
+	insecure bool
+}
+
+type sharedSession struct {
+	// ready is closed once the session is logged in, or failed to with err.
+	ready  chan struct{}
+	client *govmomi.Client
+	err    error
+	// refs is the number of VMs using the session.
+	refs int
+	// closed is set when the session is logged out once it's released.
+	closed bool
+}
+
+// NewSessionManager returns a SessionManager.
+func NewSessionManager() *SessionManager {
+	return &SessionManager{}
+}
+
+// Close logs out of all the sessions. Sessions in use are logged out when
+// the VMs using them are done, and errors doing so are returned by the next
+// call to Close.
+func (m *SessionManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.err
+	m.err = nil
+	for key, s := range m.sessions {
+		if s.refs > 0 {
+			s.closed = true
+			continue
+		}
+		if e := logoutSession(s.client); e != nil && err == nil {
+			err = e
+		}
+		delete(m.sessions, key)
+	}
+	return err
+}
+
+// acquire returns a client logged in to vm's host as its user, and a function
+// to call when the VM is done with it.
+func (m *SessionManager) acquire(vm *VM) (*govmomi.Client, func(), error) {
+	key := sessionKey{
+		host:     vm.Host,
+		username: vm.Username,
+		password: vm.Password,
+		insecure: vm.Insecure,
+	}
+
+	m.mu.Lock()
+	s, ok := m.sessions[key]
+	if !ok || s.closed {
+		// Log in without holding the lock, other VMs using the same
+		// session wait for it to be ready.
+		if m.sessions == nil {
+			m.sessions = make(map[sessionKey]*sharedSession)
+		}
+		s = &sharedSession{ready: make(chan struct{})}
+		m.sessions[key] = s
+		s.refs++
+		keepAlive := m.KeepAlive
+		m.mu.Unlock()
+
+		if keepAlive == 0 {
+			keepAlive = defaultKeepAlive
+		}
+		s.client, s.err = newSessionClient(vm.uri, vm.Insecure, keepAlive)
+		close(s.ready)
+	} else {
+		s.refs++
+		m.mu.Unlock()
+		<-s.ready
+	}
+	if s.err != nil {
+		m.release(key, s)
+		return nil, nil, s.err
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() { m.release(key, s) })
+	}
+	return s.client, release, nil
+}
+
+func (m *SessionManager) release(key sessionKey, s *sharedSession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.refs--
+	if s.err != nil {
+		// Failed logins aren't shared, the next VM logs in again.
+		if m.sessions[key] == s {
+			delete(m.sessions, key)
+		}
+		return
+	}
+	if s.refs == 0 && s.closed {
+		if err := logoutSession(s.client); err != nil && m.err == nil {
+			m.err = err
+		}
+		if m.sessions[key] == s {
+			delete(m.sessions, key)
+		}
+	}
+}
+
+// newSessionClient returns a client logged in to u that's kept alive and
+// logs in again when its session expires.
+var newSessionClient = func(u *url.URL, insecure bool, keepAlive time.Duration) (*govmomi.Client, error) {
+	ctx := context.Background()
+	vimClient, err := vim25.NewClient(ctx, soap.NewClient(u, insecure))
+	if err != nil {
+		return nil, err
+	}
+	k := newKeepAliveRoundTripper(vimClient.RoundTripper, keepAlive)
+	vimClient.RoundTripper = &reloginRoundTripper{
+		RoundTripper: k,
+		client:       vimClient,
+		user:         u.User,
+	}
+
+	client := &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: session.NewManager(vimClient),
+	}
+	if err = client.Login(ctx, u.User); err != nil {
+		return nil, err
+	}
+	k.start(vimClient)
+	return client, nil
+}
+
+var logoutSession = func(c *govmomi.Client) error {
+	if r, ok := c.RoundTripper.(*reloginRoundTripper); ok {
+		if k, ok := r.RoundTripper.(*keepAliveRoundTripper); ok {
+			k.stop()
+		}
+	}
+	return c.Logout(context.Background())
+}
+
+// keepAliveRoundTripper sends a request once the session has been idle for
+// idleTime, so that it doesn't expire. The keep alive of govmomi isn't used
+// since the request it sends doesn't need a session, which doesn't keep the
+// session alive on vCenter.
+type keepAliveRoundTripper struct {
+	soap.RoundTripper
+	idleTime time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	done chan struct{}
+	once sync.Once
+}
+
+func newKeepAliveRoundTripper(rt soap.RoundTripper, idleTime time.Duration) *keepAliveRoundTripper {
+	return &keepAliveRoundTripper{
+		RoundTripper: rt,
+		idleTime:     idleTime,
+		last:         time.Now(),
+		done:         make(chan struct{}),
+	}
+}
+
+func (k *keepAliveRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	k.mu.Lock()
+	k.last = time.Now()
+	k.mu.Unlock()
+	return k.RoundTripper.RoundTrip(ctx, req, res)
+}
+
+// start keeps the session of c alive until stop is called. The request is
+// sent through c so that it logs in again if the session expired anyway.
+func (k *keepAliveRoundTripper) start(c soap.RoundTripper) {
+	go func() {
+		t := time.NewTimer(k.idleTime)
+		defer t.Stop()
+		for {
+			select {
+			case <-k.done:
+				return
+			case <-t.C:
+			}
+			k.mu.Lock()
+			idle := time.Since(k.last)
+			k.mu.Unlock()
+			if idle >= k.idleTime {
+				req := types.CurrentTime{This: serviceInstance}
+				methods.CurrentTime(context.Background(), c, &req)
+				idle = 0
+			}
+			t.Reset(k.idleTime - idle)
+		}
+	}()
+}
+
+func (k *keepAliveRoundTripper) stop() {
+	k.once.Do(func() { close(k.done) })
+}
+
+var serviceInstance = types.ManagedObjectReference{
+	Type:  "ServiceInstance",
+	Value: "ServiceInstance",
+}
+
+// reloginRoundTripper logs in again and retries requests that fail because
+// the session expired.
+type reloginRoundTripper struct {
+	soap.RoundTripper
+	client *vim25.Client
+	user   *url.Userinfo
+	mu     sync.Mutex
+	// logins counts the logins done, so that the requests that failed
+	// because the same session expired only log in once.
+	logins uint64
+}
+
+func (r *reloginRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	// Not read under mu, since logging in goes through this RoundTripper.
+	logins := atomic.LoadUint64(&r.logins)
+	err := r.RoundTripper.RoundTrip(ctx, req, res)
+	if !isNotAuthenticated(err) {
+		return err
+	}
+
+	r.mu.Lock()
+	if atomic.LoadUint64(&r.logins) == logins {
+		err = session.NewManager(r.client).Login(ctx, r.user)
+		if err == nil {
+			atomic.AddUint64(&r.logins, 1)
+		}
+	} else {
+		err = nil
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Retry into a fresh response, res still has the fault of the first
+	// attempt, and copy it over.
+	retry := reflect.New(reflect.TypeOf(res).Elem())
+	if err = r.RoundTripper.RoundTrip(ctx, req, retry.Interface().(soap.HasFault)); err != nil {
+		return err
+	}
+	reflect.ValueOf(res).Elem().Set(retry.Elem())
+	return nil
+}
+
+func isNotAuthenticated(err error) bool {
+	if err == nil || !soap.IsSoapFault(err) {
+		return false
+	}
+	switch soap.ToSoapFault(err).VimFault().(type) {
+	case types.NotAuthenticated, *types.NotAuthenticated:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Snapshot is a snapshot of a VM, with the snapshots taken after it.
+type Snapshot struct {
+	Name        string
+	Description string
+	Created     time.Time
+	// State is the power state of the VM when the snapshot was taken.
+	State    string
+	Quiesced bool
+	// Current is set for the snapshot the VM is running from.
+	Current  bool
+	Children []Snapshot
+}
+
+// CreateSnapshot takes a snapshot of the VM. memory includes the memory of a
+// running VM, so reverting resumes it. quiesce flushes the guest's file
+// systems first, which needs VMware Tools.
+func (vm *VM) CreateSnapshot(name, description string, memory, quiesce bool) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.cancel()
+
+	vmObj, _, err := snapshotInfo(vm)
+	if err != nil {
+		return err
+	}
+	_, err = createSnapshot(vm, vmObj, name, description, memory, quiesce)
+	return err
+}
+
+// ListSnapshots returns the snapshot trees of the VM.
+func (vm *VM) ListSnapshots() ([]Snapshot, error) {
+	if err := SetupSession(vm); err != nil {
+		return nil, err
+	}
+	defer vm.cancel()
+
+	_, info, err := snapshotInfo(vm)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return snapshotTree(info.RootSnapshotList, info.CurrentSnapshot), nil
+}
+
+// RevertToSnapshot reverts the VM to the snapshot named name. The VM is
+// powered off, or suspended if the snapshot includes the memory.
+func (vm *VM) RevertToSnapshot(name string) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.cancel()
+
+	snapshot, err := findVMSnapshot(vm, name)
+	if err != nil {
+		return err
+	}
+	res, err := methods.RevertToSnapshot_Task(vm.ctx, vm.client.Client, &types.RevertToSnapshot_Task{
+		This: snapshot,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating a revert task on the snapshot: %s", err)
+	}
+	return waitForTask(vm, object.NewTask(vm.client.Client, res.Returnval), "revert")
+}
+
+// RemoveSnapshot deletes the snapshot named name, and the snapshots taken
+// after it if removeChildren is set. consolidate merges the disks the VM no
+// longer needs into their parents.
+func (vm *VM) RemoveSnapshot(name string, removeChildren, consolidate bool) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.cancel()
+
+	snapshot, err := findVMSnapshot(vm, name)
+	if err != nil {
+		return err
+	}
+	res, err := methods.RemoveSnapshot_Task(vm.ctx, vm.client.Client, &types.RemoveSnapshot_Task{
+		This:           snapshot,
+		RemoveChildren: removeChildren,
+		Consolidate:    types.NewBool(consolidate),
+	})
+	if err != nil {
+		return fmt.Errorf("error creating a remove task on the snapshot: %s", err)
+	}
+	return waitForTask(vm, object.NewTask(vm.client.Client, res.Returnval), "remove snapshot")
+}
+
+// snapshotInfo returns the VM's object and snapshots, which are nil if it
+// doesn't have any.
+var snapshotInfo = func(vm *VM) (*object.VirtualMachine, *types.VirtualMachineSnapshotInfo, error) {
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return nil, nil, err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapMo := mo.VirtualMachine{}
+	err = vm.collector.RetrieveOne(vm.ctx, vmMo.Reference(), []string{"snapshot"}, &snapMo)
+	if err != nil {
+		return nil, nil, NewErrorPropertyRetrieval(vmMo.Reference(), []string{"snapshot"}, err)
+	}
+	return object.NewVirtualMachine(vm.client.Client, vmMo.Reference()), snapMo.Snapshot, nil
+}
+
+// findVMSnapshot returns the VM's snapshot named name.
+func findVMSnapshot(vm *VM, name string) (types.ManagedObjectReference, error) {
+	_, info, err := snapshotInfo(vm)
+	if err != nil {
+		return types.ManagedObjectReference{}, err
+	}
+	if info != nil {
+		snapshot, err := findSnapshot(info.RootSnapshotList, name)
+		if err != nil || snapshot != nil {
+			return snapshotRef(snapshot), err
+		}
+	}
+	return types.ManagedObjectReference{}, NewErrorObjectNotFound(errors.New("could not find the snapshot"), name)
+}
+
+// createSnapshot takes a snapshot of the VM and returns it.
+var createSnapshot = func(vm *VM, vmObj *object.VirtualMachine, name, description string, memory, quiesce bool) (types.ManagedObjectReference, error) {
+	t, err := vmObj.CreateSnapshot(vm.ctx, name, description, memory, quiesce)
+	if err != nil {
+		return types.ManagedObjectReference{}, fmt.Errorf("error creating a snapshot task on the vm: %s", err)
+	}
+	tInfo, err := t.WaitForResult(vm.ctx, nil)
+	if err != nil {
+		return types.ManagedObjectReference{}, fmt.Errorf("error waiting for snapshot task: %s", err)
+	}
+	if tInfo.Error != nil {
+		return types.ManagedObjectReference{}, fmt.Errorf("snapshot task returned an error: %s", tInfo.Error.LocalizedMessage)
+	}
+	return tInfo.Result.(types.ManagedObjectReference), nil
+}
+
+// waitForTask waits for the task to finish, and returns its error if it
+// failed.
+func waitForTask(vm *VM, t *object.Task, name string) error {
+	tInfo, err := t.WaitForResult(vm.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error waiting for %s task: %s", name, err)
+	}
+	if tInfo.Error != nil {
+		return fmt.Errorf("%s task returned an error: %s", name, tInfo.Error.LocalizedMessage)
+	}
+	return nil
+}
+
+// findSnapshot returns the snapshot named name in the tree, or nil. Names
+// don't have to be unique, so ErrorAmbiguousSnapshot is returned if more than
+// one snapshot has it.
+func findSnapshot(tree []types.VirtualMachineSnapshotTree, name string) (*types.ManagedObjectReference, error) {
+	snapshots := findSnapshots(tree, name)
+	switch len(snapshots) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &snapshots[0], nil
+	}
+	return nil, ErrorAmbiguousSnapshot
+}
+
+// findSnapshots returns all the snapshots named name in the tree.
+func findSnapshots(tree []types.VirtualMachineSnapshotTree, name string) []types.ManagedObjectReference {
+	var snapshots []types.ManagedObjectReference
+	for _, node := range tree {
+		if node.Name == name {
+			snapshots = append(snapshots, node.Snapshot)
+		}
+		snapshots = append(snapshots, findSnapshots(node.ChildSnapshotList, name)...)
+	}
+	return snapshots
+}
+
+// snapshotTree converts the snapshot tree of a VM with the current snapshot.
+func snapshotTree(tree []types.VirtualMachineSnapshotTree, current *types.ManagedObjectReference) []Snapshot {
+	var snapshots []Snapshot
+	for _, node := range tree {
+		snapshots = append(snapshots, Snapshot{
+			Name:        node.Name,
+			Description: node.Description,
+			Created:     node.CreateTime,
+			State:       string(node.State),
+			Quiesced:    node.Quiesced,
+			Current:     current != nil && *current == node.Snapshot,
+			Children:    snapshotTree(node.ChildSnapshotList, current),
+		})
+	}
+	return snapshots
+}
@@ -0,0 +1,201 @@
+// Copyright 2016 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// isRemoteOvf returns true if the OVF or OVA at location is served over
+// http(s) rather than read from disk.
+func isRemoteOvf(location string) bool {
+	l := strings.ToLower(location)
+	return strings.HasPrefix(l, "http://") || strings.HasPrefix(l, "https://")
+}
+
+// isOva returns true if location is an OVA archive rather than an OVF
+// descriptor.
+func isOva(location string) bool {
+	p := location
+	if isRemoteOvf(location) {
+		if u, err := url.Parse(location); err == nil {
+			p = u.Path
+		}
+	}
+	return strings.EqualFold(path.Ext(p), ".ova")
+}
+
+// ovfFileLocation returns the location of the file name referenced by the OVF
+// descriptor at location.
+func ovfFileLocation(location, name string) (string, error) {
+	if isRemoteOvf(location) {
+		base, err := url.Parse(location)
+		if err != nil {
+			return "", NewErrorParsingURL(location, err)
+		}
+		ref, err := url.Parse(name)
+		if err != nil {
+			return "", NewErrorParsingURL(name, err)
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	return filepath.Join(filepath.Dir(location), name), nil
+}
+
+// httpGet downloads rawURL and returns the body and its length, which needs
+// to be known up front to report the progress of an upload.
+var httpGet = func(rawURL string) (io.ReadCloser, int64, error) {
+	resp, err := http.Get(rawURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, NewErrorBadResponse(resp)
+	}
+	if resp.ContentLength < 0 {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("no content length returned for %s", rawURL)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// openOvfSource opens the OVF or OVA at location, on disk or over http(s).
+func openOvfSource(location string) (io.ReadCloser, error) {
+	if isRemoteOvf(location) {
+		body, _, err := httpGet(location)
+		return body, err
+	}
+	return open(location)
+}
+
+// readOvaDescriptor returns the OVF descriptor out of the OVA read from r.
+// The OVF spec requires it to be the first file of the archive.
+func readOvaDescriptor(r io.Reader) (string, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", fmt.Errorf("no ovf descriptor found in the ova")
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading the ova: %s", err)
+		}
+		if !strings.EqualFold(path.Ext(hdr.Name), ".ovf") {
+			continue
+		}
+		ovfContent, err := readAll(tr)
+		if err != nil {
+			return "", fmt.Errorf("error reading the ovf descriptor from the ova: %s", err)
+		}
+		return string(ovfContent), nil
+	}
+}
+
+// uploadOva streams the files referenced by the import spec out of the OVA at
+// vm.OvfPath into the NFC lease, in the order they're stored in the archive.
+var uploadOva = func(vm *VM, specResult *types.OvfCreateImportSpecResult, leaseInfo *types.HttpNfcLeaseInfo, lease Lease) error {
+	urls := make(map[string]string, len(leaseInfo.DeviceUrl))
+	for _, device := range leaseInfo.DeviceUrl {
+		u := device.Url
+		if strings.Contains(u, "*") {
+			u = strings.Replace(u, "*", vm.Host, 1)
+		}
+		urls[device.ImportKey] = u
+	}
+	items := make(map[string]types.OvfFileItem, len(specResult.FileItem))
+	var total int64
+	for _, item := range specResult.FileItem {
+		if _, ok := urls[item.DeviceId]; !ok {
+			return fmt.Errorf("no device url found in the nfc lease for %s", item.Path)
+		}
+		items[path.Clean(item.Path)] = item
+		total += item.Size
+	}
+
+	ova, err := openOvfSource(vm.OvfPath)
+	if err != nil {
+		return fmt.Errorf("Failed to open the ova file: %s", err)
+	}
+	defer ova.Close()
+
+	var uploaded int64
+	stop := reportProgress(lease, total, &uploaded)
+	defer stop()
+
+	tr := tar.NewReader(ova)
+	for len(items) > 0 {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading the ova: %s", err)
+		}
+		item, ok := items[path.Clean(hdr.Name)]
+		if !ok {
+			continue
+		}
+		delete(items, path.Clean(hdr.Name))
+
+		method := "POST"
+		if item.Create {
+			method = "PUT"
+		}
+		r := &progressReader{r: tr, n: &uploaded}
+		err = createRequest(r, method, vm.Insecure, hdr.Size, urls[item.DeviceId], uploadContentType(item.Path))
+		if err != nil {
+			return err
+		}
+	}
+	if len(items) > 0 {
+		var missing []string
+		for p := range items {
+			missing = append(missing, p)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("files missing from the ova: %s", strings.Join(missing, ", "))
+	}
+	lease.HTTPNfcLeaseProgress(100)
+	return lease.Complete()
+}
+
+// uploadContentType returns the content type to upload the OVA file name
+// with. Disks are stream optimized, other files like ISOs are sent as is.
+func uploadContentType(name string) string {
+	if strings.EqualFold(path.Ext(name), ".vmdk") {
+		return "application/x-vnd.vmware-streamVmdk"
+	}
+	return "application/octet-stream"
+}
+
+// propertyMapping returns the vApp properties to set at import time, sorted
+// by key.
+func propertyMapping(properties map[string]string) []types.KeyValue {
+	if len(properties) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	mapping := make([]types.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		mapping = append(mapping, types.KeyValue{Key: k, Value: properties[k]})
+	}
+	return mapping
+}
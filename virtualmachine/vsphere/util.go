@@ -4,6 +4,7 @@ package vsphere
 
 import (
 	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -12,8 +13,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -23,9 +28,10 @@ import (
 	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
 
-	"github.com/apcera/libretto/util"
+	"github.com/apcera/libretto/cloudinit"
 	lvm "github.com/apcera/libretto/virtualmachine"
 )
 
@@ -66,9 +72,24 @@ var SetupSession = func(vm *VM) error {
 	u.User = url.UserPassword(vm.Username, vm.Password)
 	vm.uri = u
 	vm.ctx, vm.cancel = context.WithCancel(context.Background())
-	client, err := newClient(vm)
-	if err != nil {
-		return NewErrorClientFailed(err)
+	var client *govmomi.Client
+	if vm.Sessions != nil {
+		var release func()
+		client, release, err = vm.Sessions.acquire(vm)
+		if err != nil {
+			vm.cancel()
+			return NewErrorClientFailed(err)
+		}
+		cancel := vm.cancel
+		vm.cancel = func() {
+			cancel()
+			release()
+		}
+	} else {
+		client, err = newClient(vm)
+		if err != nil {
+			return NewErrorClientFailed(err)
+		}
 	}
 
 	vm.client = client
@@ -86,7 +107,7 @@ func GetDatacenter(vm *VM) (*mo.Datacenter, error) {
 	}
 	for _, dc := range dcList {
 		dcMo := mo.Datacenter{}
-		ps := []string{"name", "hostFolder", "vmFolder", "datastore"}
+		ps := []string{"name", "hostFolder", "vmFolder", "datastoreFolder", "datastore"}
 		err := vm.collector.RetrieveOne(vm.ctx, dc.Reference(), ps, &dcMo)
 		if err != nil {
 			return nil, NewErrorPropertyRetrieval(dc.Reference(), ps, err)
@@ -107,6 +128,22 @@ var readAll = func(r io.Reader) ([]byte, error) {
 }
 
 var parseOvf = func(ovfLocation string) (string, error) {
+	if isRemoteOvf(ovfLocation) || isOva(ovfLocation) {
+		ovf, err := openOvfSource(ovfLocation)
+		if err != nil {
+			return "", fmt.Errorf("Failed to open the ovf file: %s", err)
+		}
+		defer ovf.Close()
+		if isOva(ovfLocation) {
+			return readOvaDescriptor(ovf)
+		}
+		ovfContent, err := readAll(ovf)
+		if err != nil {
+			return "", fmt.Errorf("Failed to open the ovf file: %s", err)
+		}
+		return string(ovfContent), nil
+	}
+
 	ovf, err := open(ovfLocation)
 	if err != nil {
 		return "", fmt.Errorf("Failed to open the ovf file: %s", err)
@@ -149,6 +186,88 @@ var findClusterComputeResource = func(vm *VM, dc *mo.Datacenter, name string) (*
 	return &cr, nil
 }
 
+// findResourcePool finds the resource pool or vApp at path in the given dc.
+// The path starts with the name of a host or cluster, followed by the names of
+// the nested pools, as in "cluster/team-a/ci". The host or cluster's root pool
+// may be named "Resources" in the path, as in the vSphere inventory.
+var findResourcePool = func(vm *VM, dc *mo.Datacenter, path string) (*mo.ResourcePool, error) {
+	names := strings.Split(strings.Trim(path, "/"), "/")
+	crMor, err := findMob(vm, dc.HostFolder, names[0])
+	if err != nil {
+		return nil, err
+	}
+	if crMor == nil {
+		return nil, NewErrorObjectNotFound(errors.New("Could not find the mob"), names[0])
+	}
+	crMo, err := retrieveComputeResource(vm, *crMor, []string{"resourcePool"})
+	if err != nil {
+		return nil, err
+	}
+	if crMo.ResourcePool == nil {
+		return nil, NewErrorObjectNotFound(errors.New("no root resource pool"), names[0])
+	}
+
+	names = names[1:]
+	if len(names) > 0 && names[0] == "Resources" {
+		names = names[1:]
+	}
+
+	ps := []string{"name", "owner", "resourcePool"}
+	rpMo, err := retrieveResourcePool(vm, *crMo.ResourcePool, ps)
+	if err != nil {
+		return nil, err
+	}
+Children:
+	for _, name := range names {
+		for _, child := range rpMo.ResourcePool {
+			childMo, err := retrieveResourcePool(vm, child, ps)
+			if err != nil {
+				return nil, err
+			}
+			if childMo.Name == name {
+				rpMo = childMo
+				continue Children
+			}
+		}
+		return nil, NewErrorObjectNotFound(errors.New("resource pool not found"), path)
+	}
+	return rpMo, nil
+}
+
+// retrieveResourcePool retrieves the properties ps of the resource pool or
+// vApp mor.
+func retrieveResourcePool(vm *VM, mor types.ManagedObjectReference, ps []string) (*mo.ResourcePool, error) {
+	if mor.Type == "VirtualApp" {
+		vaMo := mo.VirtualApp{}
+		if err := vm.collector.RetrieveOne(vm.ctx, mor, ps, &vaMo); err != nil {
+			return nil, NewErrorPropertyRetrieval(mor, ps, err)
+		}
+		return &vaMo.ResourcePool, nil
+	}
+	rpMo := mo.ResourcePool{}
+	if err := vm.collector.RetrieveOne(vm.ctx, mor, ps, &rpMo); err != nil {
+		return nil, NewErrorPropertyRetrieval(mor, ps, err)
+	}
+	return &rpMo, nil
+}
+
+// retrieveComputeResource retrieves the properties ps of the host or cluster
+// mor.
+func retrieveComputeResource(vm *VM, mor types.ManagedObjectReference, ps []string) (*mo.ComputeResource, error) {
+	if mor.Type == "ClusterComputeResource" {
+		ccrMo := mo.ClusterComputeResource{}
+		if err := vm.collector.RetrieveOne(vm.ctx, mor, ps, &ccrMo); err != nil {
+			return nil, NewErrorPropertyRetrieval(mor, ps, err)
+		}
+		return &ccrMo.ComputeResource, nil
+	}
+	crMo := mo.ComputeResource{}
+	if err := vm.collector.RetrieveOne(vm.ctx, mor, ps, &crMo); err != nil {
+		return nil, NewErrorPropertyRetrieval(mor, ps, err)
+	}
+	return &crMo, nil
+}
+
 // findDatastore finds a datastore in the given dc
 var findDatastore = func(vm *VM, dc *mo.Datacenter, name string) (*mo.Datastore, error) {
 	for _, dsMor := range dc.Datastore {
@@ -225,6 +344,9 @@ var uploadOvf = func(vm *VM, specResult *types.OvfCreateImportSpecResult, lease
 	if err != nil {
 		return fmt.Errorf("error waiting on the nfc lease: %s", err)
 	}
+	if isOva(vm.OvfPath) {
+		return uploadOva(vm, specResult, leaseInfo, lease)
+	}
 
 	//FIXME (Preet): Hard coded to just upload the first device.
 	url := leaseInfo.DeviceUrl[0].Url
@@ -232,18 +354,28 @@ var uploadOvf = func(vm *VM, specResult *types.OvfCreateImportSpecResult, lease
 		url = strings.Replace(url, "*", vm.Host, 1)
 	}
 
-	path := specResult.FileItem[0].Path
-	if !filepath.IsAbs(path) {
-		// If the path is not abs, convert it into an ABS path relative to the OVF file
-		dir := filepath.Dir(vm.OvfPath)
-		path = filepath.Join(dir, path)
-	}
-	file, err := open(path)
+	// If the path is not abs, it's relative to the OVF file
+	path, err := ovfFileLocation(vm.OvfPath, specResult.FileItem[0].Path)
 	if err != nil {
 		return err
 	}
-	info, _ := file.Stat()
-	totalBytes := info.Size()
+	var file io.Reader
+	var totalBytes int64
+	if isRemoteOvf(vm.OvfPath) {
+		body, size, err := httpGet(path)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		file, totalBytes = body, size
+	} else {
+		f, err := open(path)
+		if err != nil {
+			return err
+		}
+		info, _ := f.Stat()
+		file, totalBytes = f, info.Size()
+	}
 	reader := NewProgressReader(file, totalBytes, lease)
 	reader.StartProgress()
 	err = createRequest(reader, "POST", vm.Insecure, totalBytes, url, "application/x-vnd.vmware-streamVmdk")
@@ -307,7 +439,7 @@ func searchTree(vm *VM, mor types.ManagedObjectReference, name string) (*mo.Virt
 	case "VirtualMachine":
 		// Base recursive case, compare for value
 		vmMo := mo.VirtualMachine{}
-		err := vm.collector.RetrieveOne(vm.ctx, mor, []string{"name", "guest.ipAddress", "guest.guestState", "guest.net"}, &vmMo)
+		err := vm.collector.RetrieveOne(vm.ctx, mor, []string{"name", "runtime.powerState", "guest.ipAddress", "guest.guestState", "guest.net"}, &vmMo)
 		if err != nil {
 			return nil, NewErrorObjectNotFound(errors.New("could not find the vm"), name)
 		}
@@ -320,8 +452,10 @@ func searchTree(vm *VM, mor types.ManagedObjectReference, name string) (*mo.Virt
 }
 
 var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []string) error {
-	n := util.Random(1, len(usableDatastores))
-	vm.datastore = usableDatastores[n-1]
+	var err error
+	if vm.datastore, err = pickDatastore(vm, dcMo, usableDatastores); err != nil {
+		return err
+	}
 	dsMo, err := findDatastore(vm, dcMo, vm.datastore)
 	if err != nil {
 		return err
@@ -339,9 +473,8 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 		return err
 	}
 
-	// TODO: If the network needs to be reconfigured as well then this needs
-	// to delete all the network cards and create VirtualDevice specs.
-	// For now only configure the datastore and the host.
+	// The clone only places the VM. Its hardware and network cards are
+	// reconfigured once it's created, see reconfigureVM.
 	relocateSpec := types.VirtualMachineRelocateSpec{
 		Pool:      &l.ResourcePool,
 		Host:      &l.Host,
@@ -353,6 +486,29 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 		Template: false,
 		PowerOn:  false,
 	}
+	if vm.LinkedClone {
+		snapshot, err := templateSnapshot(vm, vmObj, template, l)
+		if err != nil {
+			return err
+		}
+		cisp.Snapshot = &snapshot
+		cisp.Location.DiskMoveType = string(types.VirtualMachineRelocateDiskMoveOptionsCreateNewChildDiskBacking)
+	}
+	if vm.CloudConfig != nil {
+		extraConfig, err := cloudInitExtraConfig(vm)
+		if err != nil {
+			return err
+		}
+		cisp.Config = &types.VirtualMachineConfigSpec{ExtraConfig: extraConfig}
+	}
+	// Storage DRS places the clone as it's specified, so this comes last.
+	if vm.DatastoreCluster != "" {
+		ds, err := recommendDatastore(vm, dcMo, vmMo.Reference(), cisp)
+		if err != nil {
+			return err
+		}
+		cisp.Location.Datastore = &ds
+	}
 	folderObj := object.NewFolder(vm.client.Client, dcMo.VmFolder)
 	t, err := vmObj.Clone(vm.ctx, folderObj, vm.Name, cisp)
 	if err != nil {
@@ -369,8 +525,11 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 	if err != nil {
 		return fmt.Errorf("failed to retrieve cloned VM: %s", err)
 	}
-	if len(vm.Disks) > 0 {
-		if err = reconfigureVM(vm, vmMo); err != nil {
+	if err = reconfigureVM(vm, vmMo, l.Networks); err != nil {
+		return err
+	}
+	if vm.Customization != nil {
+		if err = customizeVM(vm, vmMo); err != nil {
 			return err
 		}
 	}
@@ -384,24 +543,333 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 	return nil
 }
 
-var reconfigureVM = func(vm *VM, vmMo *mo.VirtualMachine) error {
+// templateLocks serializes taking snapshots of the same template, so that
+// concurrent linked clones don't convert it to a VM and snapshot it twice.
+var templateLocks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: make(map[string]*sync.Mutex)}
+
+// lockTemplate locks the template ref on host and returns the unlock func.
+func lockTemplate(host string, ref types.ManagedObjectReference) func() {
+	key := host + "/" + ref.Value
+	templateLocks.Lock()
+	l, ok := templateLocks.m[key]
+	if !ok {
+		l = &sync.Mutex{}
+		templateLocks.m[key] = l
+	}
+	templateLocks.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// templateSnapshotWait is how long to wait for a snapshot that something
+// else is taking of a template, and templateSnapshotPoll how often to check.
+var (
+	templateSnapshotWait = 5 * time.Minute
+	templateSnapshotPoll = 5 * time.Second
+)
+
+// templateSnapshot returns the snapshot of the template named template that
+// linked clones are created from, and takes it if the template doesn't have it
+// yet. A template can't be snapshotted, so it's turned into a VM for that.
+var templateSnapshot = func(vm *VM, vmObj *object.VirtualMachine, template string, l location) (types.ManagedObjectReference, error) {
+	name := vm.TemplateSnapshot
+	if name == "" {
+		name = defaultTemplateSnapshot
+	}
+
+	unlock := lockTemplate(vm.Host, vmObj.Reference())
+	defer unlock()
+
+	snapshot, err := findTemplateSnapshot(vm, vmObj, name)
+	if err != nil || snapshot != nil {
+		return snapshotRef(snapshot), err
+	}
+
+	pool := object.NewResourcePool(vm.client.Client, l.ResourcePool)
+	host := object.NewHostSystem(vm.client.Client, l.Host)
+	if err = vmObj.MarkAsVirtualMachine(vm.ctx, *pool, host); err != nil {
+		if !isInvalidState(err) {
+			return types.ManagedObjectReference{}, fmt.Errorf("error converting the template to a VM: %s", err)
+		}
+		// It's not a template anymore, most likely because another
+		// process is taking the snapshot. Wait for it to show up.
+		return waitTemplateSnapshot(vm, vmObj, template, name)
+	}
+	ref, err := createSnapshot(vm, vmObj, name, "Created by libretto for linked clones", false, false)
+	// Turn the VM back into a template even if the snapshot failed.
+	if merr := vmObj.MarkAsTemplate(vm.ctx); merr != nil {
+		merr = fmt.Errorf("error converting %s back to a template, it has to be done by hand: %s", template, merr)
+		if err != nil {
+			return types.ManagedObjectReference{}, lvm.WrapErrors(err, merr)
+		}
+		return types.ManagedObjectReference{}, merr
+	}
+	return ref, err
+}
+
+// findTemplateSnapshot returns the template's snapshot named name, or nil.
+func findTemplateSnapshot(vm *VM, vmObj *object.VirtualMachine, name string) (*types.ManagedObjectReference, error) {
+	vmMo := mo.VirtualMachine{}
+	err := vm.collector.RetrieveOne(vm.ctx, vmObj.Reference(), []string{"snapshot"}, &vmMo)
+	if err != nil {
+		return nil, NewErrorPropertyRetrieval(vmObj.Reference(), []string{"snapshot"}, err)
+	}
+	if vmMo.Snapshot == nil {
+		return nil, nil
+	}
+	return findSnapshot(vmMo.Snapshot.RootSnapshotList, name)
+}
+
+// waitTemplateSnapshot waits for the snapshot named name of the template named
+// template to be taken by something else.
+func waitTemplateSnapshot(vm *VM, vmObj *object.VirtualMachine, template, name string) (types.ManagedObjectReference, error) {
+	deadline := time.Now().Add(templateSnapshotWait)
+	for {
+		snapshot, err := findTemplateSnapshot(vm, vmObj, name)
+		if err != nil || snapshot != nil {
+			return snapshotRef(snapshot), err
+		}
+		if time.Now().After(deadline) {
+			return types.ManagedObjectReference{}, fmt.Errorf("timed out waiting for snapshot %q of %s", name, template)
+		}
+		time.Sleep(templateSnapshotPoll)
+	}
+}
+
+func snapshotRef(snapshot *types.ManagedObjectReference) types.ManagedObjectReference {
+	if snapshot == nil {
+		return types.ManagedObjectReference{}
+	}
+	return *snapshot
+}
+
+// isInvalidState returns whether err is an InvalidState fault, which is
+// returned for instance when a VM that isn't a template is marked as a VM.
+func isInvalidState(err error) bool {
+	if err == nil || !soap.IsSoapFault(err) {
+		return false
+	}
+	switch soap.ToSoapFault(err).VimFault().(type) {
+	case types.InvalidState, *types.InvalidState:
+		return true
+	}
+	return false
+}
+
+// cloudInitExtraConfig returns the guestinfo properties that hand the VM's
+// cloud config and a meta-data document to cloud-init.
+var cloudInitExtraConfig = func(vm *VM) ([]types.BaseOptionValue, error) {
+	userData, err := vm.CloudConfig.Base64()
+	if err != nil {
+		return nil, err
+	}
+	hostname := vm.CloudConfig.Hostname
+	if hostname == "" {
+		hostname = vm.Name
+	}
+	metaData := base64.StdEncoding.EncodeToString(cloudinit.MetaData(vm.Name, hostname))
+
+	return []types.BaseOptionValue{
+		&types.OptionValue{Key: "guestinfo.userdata", Value: userData},
+		&types.OptionValue{Key: "guestinfo.userdata.encoding", Value: "base64"},
+		&types.OptionValue{Key: "guestinfo.metadata", Value: metaData},
+		&types.OptionValue{Key: "guestinfo.metadata.encoding", Value: "base64"},
+	}, nil
+}
+
+// reconfigureVM applies the VM's hardware, network card and extra config
+// settings to the clone in a single reconfigure task, and adds its disks.
+var reconfigureVM = func(vm *VM, vmMo *mo.VirtualMachine, networks []types.ManagedObjectReference) error {
+	spec := hardwareSpec(vm)
 	vmObj := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
-	devices, err := vmObj.Device(vm.ctx)
+	if len(vm.Disks) > 0 || len(vm.NICs) > 0 || len(vm.RemoveNICs) > 0 || vm.ReplaceNICs {
+		devices, err := vmObj.Device(vm.ctx)
+		if err != nil {
+			return fmt.Errorf("error retrieving vm devices: %s", err)
+		}
+		changes, err := deviceChanges(vm, devices, networks)
+		if err != nil {
+			return err
+		}
+		spec.DeviceChange = changes
+	}
+	if reflect.DeepEqual(spec, types.VirtualMachineConfigSpec{}) {
+		return nil
+	}
+
+	t, err := vmObj.Reconfigure(vm.ctx, spec)
 	if err != nil {
-		return err
+		return fmt.Errorf("error reconfiguring vm: %s", err)
 	}
+	if err = t.Wait(vm.ctx); err != nil {
+		return fmt.Errorf("reconfigure task finished with error: %s", err)
+	}
+	return nil
+}
 
-	var add []types.BaseVirtualDevice
+// hardwareSpec returns a config spec with the VM's CPU, memory and extra
+// config settings. Unset settings are left out, so the template's are kept.
+func hardwareSpec(vm *VM) types.VirtualMachineConfigSpec {
+	spec := types.VirtualMachineConfigSpec{
+		NumCPUs:           int(vm.NumCPUs),
+		NumCoresPerSocket: int(vm.CoresPerSocket),
+		MemoryMB:          vm.MemoryMB,
+	}
+	if vm.CPUHotAdd {
+		spec.CpuHotAddEnabled = types.NewBool(true)
+	}
+	if vm.MemoryHotAdd {
+		spec.MemoryHotAddEnabled = types.NewBool(true)
+	}
+	if vm.CPUReservation != 0 || vm.CPULimit != 0 {
+		spec.CpuAllocation = &types.ResourceAllocationInfo{
+			Reservation: vm.CPUReservation,
+			Limit:       vm.CPULimit,
+		}
+	}
+	if vm.MemoryReservation != 0 || vm.MemoryLimit != 0 {
+		spec.MemoryAllocation = &types.ResourceAllocationInfo{
+			Reservation: vm.MemoryReservation,
+			Limit:       vm.MemoryLimit,
+		}
+	}
+
+	keys := make([]string, 0, len(vm.ExtraConfig))
+	for k := range vm.ExtraConfig {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		spec.ExtraConfig = append(spec.ExtraConfig, &types.OptionValue{Key: k, Value: vm.ExtraConfig[k]})
+	}
+	return spec
+}
+
+// deviceChanges returns the changes that add the VM's disks and network
+// cards to devices, and remove the network cards named by RemoveNICs, or all
+// of them if ReplaceNICs is set.
+func deviceChanges(vm *VM, devices object.VirtualDeviceList, networks []types.ManagedObjectReference) ([]types.BaseVirtualDeviceConfigSpec, error) {
+	var changes []types.BaseVirtualDeviceConfigSpec
+	// New devices need distinct negative keys within the spec.
+	key := 0
 	for _, disk := range vm.Disks {
 		controller, err := devices.FindDiskController(disk.Controller)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		d := devices.CreateDisk(controller, "")
 		d.CapacityInKB = disk.Size
-		add = append(add, d)
+		key--
+		d.Key = key
+		// Keep track of the disk so the next one gets another unit number.
+		devices = append(devices, d)
+		changes = append(changes, &types.VirtualDeviceConfigSpec{
+			Operation:     types.VirtualDeviceConfigSpecOperationAdd,
+			FileOperation: types.VirtualDeviceConfigSpecFileOperationCreate,
+			Device:        d,
+		})
+	}
+
+	remove := make(map[string]bool, len(vm.RemoveNICs))
+	for _, label := range vm.RemoveNICs {
+		remove[label] = true
+	}
+	for _, nic := range devices.SelectByType((*types.VirtualEthernetCard)(nil)) {
+		var label string
+		if info := nic.GetVirtualDevice().DeviceInfo; info != nil {
+			label = info.GetDescription().Label
+		}
+		if !vm.ReplaceNICs && !remove[label] {
+			continue
+		}
+		delete(remove, label)
+		changes = append(changes, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationRemove,
+			Device:    nic,
+		})
+	}
+	for _, label := range vm.RemoveNICs {
+		if remove[label] && !vm.ReplaceNICs {
+			return nil, NewErrorObjectNotFound(errors.New("could not find the network card"), label)
+		}
+	}
+
+	for _, nic := range vm.NICs {
+		backing, err := nicBacking(vm, networks, nic.Network)
+		if err != nil {
+			return nil, err
+		}
+		// govmomi picks e1000 for an empty type.
+		nicType := nic.Type
+		if nicType == "" {
+			nicType = defaultNICType
+		}
+		d, err := devices.CreateEthernetCard(nicType, backing)
+		if err != nil {
+			return nil, err
+		}
+		card := d.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+		key--
+		card.Key = key
+		if nic.MAC != "" {
+			card.AddressType = string(types.VirtualEthernetCardMacTypeManual)
+			card.MacAddress = nic.MAC
+		}
+		changes = append(changes, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device:    d,
+		})
+	}
+	return changes, nil
+}
+
+// nicBacking returns the backing of a network card connected to the port
+// group or distributed port group named name out of networks.
+var nicBacking = func(vm *VM, networks []types.ManagedObjectReference, name string) (types.BaseVirtualDeviceBackingInfo, error) {
+	for _, network := range networks {
+		n, err := getNetworkName(vm, network)
+		if err != nil {
+			return nil, err
+		}
+		if n != name {
+			continue
+		}
+		switch network.Type {
+		case "DistributedVirtualPortgroup":
+			return object.NewDistributedVirtualPortgroup(vm.client.Client, network).EthernetCardBackingInfo(vm.ctx)
+		default:
+			return object.NewNetwork(vm.client.Client, network).EthernetCardBackingInfo(vm.ctx)
+		}
 	}
-	return vmObj.AddDevice(vm.ctx, add...)
+	return nil, NewErrorObjectNotFound(errors.New("could not find the network"), name)
+}
+
+// customizeVM customizes the guest of the clone with the VM's customization
+// spec. The guest is customized when it's next powered on.
+var customizeVM = func(vm *VM, vmMo *mo.VirtualMachine) error {
+	vmObj := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	devices, err := vmObj.Device(vm.ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving vm devices: %s", err)
+	}
+	nics := len(devices.SelectByType((*types.VirtualEthernetCard)(nil)))
+	spec, err := customizationSpec(vm, nics)
+	if err != nil {
+		return fmt.Errorf("error creating customization spec: %s", err)
+	}
+
+	t, err := vmObj.Customize(vm.ctx, *spec)
+	if err != nil {
+		return fmt.Errorf("error customizing vm: %s", err)
+	}
+	if err = t.Wait(vm.ctx); err != nil {
+		return fmt.Errorf("customization task finished with error: %s", err)
+	}
+	return nil
 }
 
 var waitForIP = func(vm *VM, vmMo *mo.VirtualMachine) error {
@@ -419,6 +887,35 @@ var waitForIP = func(vm *VM, vmMo *mo.VirtualMachine) error {
 	return nil
 }
 
+// guestAddresses converts the guest network information reported by VMware
+// Tools into addresses. The NIC index is the position of the card in
+// guest.net and the interface is the key of its virtual device, left empty
+// when vSphere couldn't map the card to one.
+var guestAddresses = func(vmMo *mo.VirtualMachine) []lvm.Address {
+	addrs := []lvm.Address{}
+	for i, nic := range vmMo.Guest.Net {
+		for _, ip := range nic.IpAddress {
+			netIP := net.ParseIP(ip)
+			if netIP == nil {
+				continue
+			}
+			a := lvm.NewAddress(netIP, i, "")
+			if nic.DeviceConfigId >= 0 {
+				a.Interface = strconv.Itoa(nic.DeviceConfigId)
+			}
+			a.MAC = nic.MacAddress
+			a.Network = nic.Network
+			addrs = append(addrs, a)
+		}
+	}
+	if len(addrs) == 0 && vmMo.Guest.IpAddress != "" {
+		if ip := net.ParseIP(vmMo.Guest.IpAddress); ip != nil {
+			addrs = append(addrs, lvm.NewAddress(ip, lvm.NICUnknown, ""))
+		}
+	}
+	return addrs
+}
+
 var halt = func(vm *VM) error {
 	// Get a reference to the datacenter with host and vm folders populated
 	dcMo, err := GetDatacenter(vm)
@@ -444,6 +941,45 @@ var halt = func(vm *VM) error {
 	return nil
 }
 
+// shutdown shuts the guest down through VMware Tools, and powers the VM off
+// if it isn't off after vm.ShutdownTimeout, or if Tools isn't running.
+var shutdown = func(vm *VM) error {
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+	if vmMo.Guest == nil || vmMo.Guest.GuestState != "running" {
+		return halt(vm)
+	}
+
+	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	if err = vmo.ShutdownGuest(vm.ctx); err != nil {
+		return halt(vm)
+	}
+	ctx, cancel := context.WithTimeout(vm.ctx, vm.ShutdownTimeout)
+	defer cancel()
+	if err = vmo.WaitForPowerState(ctx, types.VirtualMachinePowerStatePoweredOff); err != nil {
+		if ctx.Err() == context.DeadlineExceeded && vm.ctx.Err() == nil {
+			// The guest may have just finished shutting down, and
+			// powering it off would fail then.
+			if vmMo, err = findVM(vm, dcMo, vm.Name); err != nil {
+				return err
+			}
+			if vmMo.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff {
+				return nil
+			}
+			return halt(vm)
+		}
+		return fmt.Errorf("error waiting for the guest to shut down: %s", err)
+	}
+	return nil
+}
+
 var start = func(vm *VM) error {
 	// Get a reference to the datacenter with host and vm folders populated
 	dcMo, err := GetDatacenter(vm)
@@ -454,9 +990,11 @@ var start = func(vm *VM) error {
 	if err != nil {
 		return err
 	}
-	state := vmMo.Guest.GuestState
-	if state == "shuttingdown" || state == "resetting" {
-		return ErrorVMPowerStateChanging
+	if vmMo.Guest != nil {
+		state := vmMo.Guest.GuestState
+		if state == "shuttingDown" || state == "resetting" {
+			return ErrorVMPowerStateChanging
+		}
 	}
 	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
 	poweronTask, err := vmo.PowerOn(vm.ctx)
@@ -490,6 +1028,35 @@ var filterHosts = func(vm *VM, hosts []types.ManagedObjectReference) ([]types.Ma
 	return filteredHosts, nil
 }
 
+// selectHost returns the host named by vm.Destination.HostSystem out of hosts
+// if it's set, or the least loaded host that has the VM's datastore and
+// networks.
+var selectHost = func(vm *VM, hosts []types.ManagedObjectReference) (types.ManagedObjectReference, error) {
+	if vm.Destination.HostSystem != "" {
+		hsMo, err := findHostSystem(vm, hosts, vm.Destination.HostSystem)
+		if err != nil {
+			return types.ManagedObjectReference{}, err
+		}
+		valid, err := validateHost(vm, hsMo.Reference())
+		if err != nil {
+			return types.ManagedObjectReference{}, err
+		}
+		if !valid {
+			return types.ManagedObjectReference{}, NewErrorInvalidHost(vm.Destination.HostSystem, vm.datastore, vm.Networks)
+		}
+		return hsMo.Reference(), nil
+	}
+
+	filteredHosts, err := filterHosts(vm, hosts)
+	if err != nil {
+		return types.ManagedObjectReference{}, err
+	}
+	if len(filteredHosts) <= 0 {
+		return types.ManagedObjectReference{}, fmt.Errorf("No suitable hosts found in the cluster")
+	}
+	return leastLoadedHost(vm, filteredHosts)
+}
+
 var getVMLocation = func(vm *VM, dcMo *mo.Datacenter) (l location, err error) {
 	switch vm.Destination.DestinationType {
 	case DestinationTypeHost:
@@ -525,35 +1092,9 @@ var getVMLocation = func(vm *VM, dcMo *mo.Datacenter) (l location, err error) {
 			return
 		}
 		// If a host name was passed in try to find it within the cluster
-		if vm.Destination.HostSystem != "" {
-			var mo *mo.HostSystem
-			mo, err = findHostSystem(vm, crMo.Host, vm.Destination.HostSystem)
-			if err != nil {
-				return
-			}
-			var valid bool
-			valid, err = validateHost(vm, mo.Reference())
-			if err != nil {
-				return
-			}
-			if !valid {
-				err = NewErrorInvalidHost(vm.Destination.HostSystem, vm.datastore, vm.Networks)
-				return
-			}
-			ref := mo.Reference()
-			l.Host = ref
-		} else {
-			var filteredHosts []types.ManagedObjectReference
-			filteredHosts, err = filterHosts(vm, crMo.Host)
-			if err != nil {
-				return
-			}
-			if len(filteredHosts) <= 0 {
-				err = fmt.Errorf("No suitable hosts found in the cluster")
-				return
-			}
-			n := util.Random(1, len(filteredHosts))
-			l.Host = filteredHosts[n-1]
+		l.Host, err = selectHost(vm, crMo.Host)
+		if err != nil {
+			return
 		}
 		if crMo.ResourcePool == nil {
 			err = fmt.Errorf("No valid resource pool found on the host")
@@ -561,6 +1102,32 @@ var getVMLocation = func(vm *VM, dcMo *mo.Datacenter) (l location, err error) {
 		}
 		l.ResourcePool = *crMo.ResourcePool
 		l.Networks = crMo.Network
+	case DestinationTypeResourcePool:
+		var rpMo *mo.ResourcePool
+		rpMo, err = findResourcePool(vm, dcMo, vm.Destination.DestinationName)
+		if err != nil {
+			return
+		}
+		if rpMo.Owner.Value == "" {
+			err = fmt.Errorf("No owner found for the resource pool %q", vm.Destination.DestinationName)
+			return
+		}
+		// Pick a host out of the host or cluster the pool belongs to
+		var crMo *mo.ComputeResource
+		crMo, err = retrieveComputeResource(vm, rpMo.Owner, []string{"name", "host", "network"})
+		if err != nil {
+			return
+		}
+		if len(crMo.Host) <= 0 {
+			err = errNoHostsInCluster
+			return
+		}
+		l.Host, err = selectHost(vm, crMo.Host)
+		if err != nil {
+			return
+		}
+		l.ResourcePool = rpMo.Reference()
+		l.Networks = crMo.Network
 	default:
 		err = ErrorDestinationNotSupported
 		return
@@ -600,7 +1167,7 @@ var uploadTemplate = func(vm *VM, dcMo *mo.Datacenter, selectedDatastore string)
 		HostSystem:       &l.Host,
 		EntityName:       template,
 		DiskProvisioning: "thin",
-		PropertyMapping:  nil,
+		PropertyMapping:  propertyMapping(vm.OvfProperties),
 		NetworkMapping:   networkMapping,
 	}
 
@@ -623,7 +1190,11 @@ var uploadTemplate = func(vm *VM, dcMo *mo.Datacenter, selectedDatastore string)
 
 	hso := object.NewHostSystem(vm.client.Client, l.Host)
 	// Import into the DC's vm folder for now. We can make it user configurable later.
-	fo := object.NewFolder(vm.client.Client, dcMo.VmFolder)
+	// A vApp holds the VMs imported into it, so no folder is passed then.
+	var fo *object.Folder
+	if l.ResourcePool.Type != "VirtualApp" {
+		fo = object.NewFolder(vm.client.Client, dcMo.VmFolder)
+	}
 	lease, err := rpo.ImportVApp(vm.ctx, specResult.ImportSpec, fo, hso)
 	if err != nil {
 		return fmt.Errorf("error getting an nfc lease: %s", err)
@@ -631,6 +1202,7 @@ var uploadTemplate = func(vm *VM, dcMo *mo.Datacenter, selectedDatastore string)
 
 	err = uploadOvf(vm, specResult, NewLease(vm.ctx, lease))
 	if err != nil {
+		lease.HttpNfcLeaseAbort(vm.ctx, nil)
 		return fmt.Errorf("error uploading the ovf template: %s", err)
 	}
 
@@ -709,18 +1281,40 @@ func validateHost(vm *VM, hsMor types.ManagedObjectReference) (bool, error) {
 	return (nwValid && dsValid), nil
 }
 
-func getState(vm *VM) (state string, err error) {
+// getState returns the power state of the VM: poweredOn, poweredOff or
+// suspended.
+var getState = func(vm *VM) (state string, err error) {
+	vmMo, err := getStateVM(vm)
+	if err != nil {
+		return "", err
+	}
+	return string(vmMo.Runtime.PowerState), nil
+}
+
+// getGuestState returns the state of the guest as seen by VMware Tools, or
+// "unknown" if Tools isn't running.
+var getGuestState = func(vm *VM) (state string, err error) {
+	vmMo, err := getStateVM(vm)
+	if err != nil {
+		return "", err
+	}
+	if vmMo.Guest == nil || vmMo.Guest.GuestState == "" {
+		return "unknown", nil
+	}
+	return vmMo.Guest.GuestState, nil
+}
+
+func getStateVM(vm *VM) (*mo.VirtualMachine, error) {
 	// Get a reference to the datacenter with host and vm folders populated
 	dcMo, err := GetDatacenter(vm)
 	if err != nil {
-		return "", lvm.ErrVMInfoFailed
+		return nil, lvm.ErrVMInfoFailed
 	}
 	vmMo, err := findVM(vm, dcMo, vm.Name)
 	if err != nil {
-		return "", lvm.ErrVMInfoFailed
+		return nil, lvm.ErrVMInfoFailed
 	}
-
-	return vmMo.Guest.GuestState, nil
+	return vmMo, nil
 }
 
 func init() {
@@ -736,9 +1330,12 @@ func init() {
 			if child.Type == "Folder" {
 				// Search here first
 				found, err := findMob(vm, child, name)
-				if err != nil {
+				if err == nil {
 					return found, nil
 				}
+				if _, ok := err.(ErrorObjectNotFound); !ok {
+					return nil, err
+				}
 			}
 			if child.Type == "ComputeResource" {
 				cr := mo.ComputeResource{}
@@ -13,6 +13,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/apcera/libretto/cloudinit"
 	"github.com/apcera/libretto/ssh"
 	"github.com/apcera/libretto/util"
 	lvm "github.com/apcera/libretto/virtualmachine"
@@ -144,6 +145,12 @@ var (
 	// The VM can't be started in this state
 	ErrorVMPowerStateChanging = errors.New("the power state of the vm is changing, try again later")
 	errNoHostsInCluster       = errors.New("the cluster does not have any hosts in it")
+	// ErrorNoDatastore is returned when none of the datastores of the VM can
+	// be used, because they're full, inaccessible or in maintenance mode.
+	ErrorNoDatastore = errors.New("no usable datastore found")
+	// ErrorAmbiguousSnapshot is returned when more than one snapshot of the
+	// VM has the name it's looked up by.
+	ErrorAmbiguousSnapshot = errors.New("ambiguous snapshot name, more than one snapshot has it")
 )
 
 // ErrorParsingURL is returned when the sdk url passed to the vSphere provider is not valid
@@ -252,6 +259,14 @@ const (
 	DestinationTypeResourcePool = "resource_pool"
 )
 
+// defaultNICType is the type of the network cards added to the VM when
+// NIC.Type isn't set.
+const defaultNICType = "vmxnet3"
+
+// defaultTemplateSnapshot is the snapshot linked clones are created from when
+// VM.TemplateSnapshot isn't set.
+const defaultTemplateSnapshot = "libretto-linked-clone"
+
 type collector interface {
 	RetrieveOne(context.Context, types.ManagedObjectReference, []string, interface{}) error
 }
@@ -262,6 +277,16 @@ type Disk struct {
 	Controller string
 }
 
+// NIC represents a network card to add to the VM
+type NIC struct {
+	// Network is the name of a port group or a distributed port group.
+	Network string
+	// Type is "vmxnet3", the default, "e1000" or "e1000e".
+	Type string
+	// MAC is a manual MAC address. One is generated when empty.
+	MAC string
+}
+
 type finder interface {
 	DatacenterList(context.Context, string) ([]*object.Datacenter, error)
 }
@@ -282,13 +307,15 @@ type location struct {
 
 // Destination represents a destination on which to provision a Virtual Machine
 type Destination struct {
-	// Represents the name of the destination as described in the API
+	// Represents the name of the destination as described in the API. For a
+	// resource pool it is the path to the pool, starting with the name of its
+	// host or cluster, as in "cluster/team-a/ci". vApps are resource pools.
 	DestinationName string
-	// Only the "host" type is supported for now. The VI SDK supports host, cluster
-	// and resource pool.
+	// One of DestinationTypeHost, DestinationTypeCluster or
+	// DestinationTypeResourcePool.
 	DestinationType string
 	// HostSystem specifies the name of the host to run the VM on. DestinationType ESXi
-	// will have one host system. A cluster will have more than one,
+	// will have one host system. A cluster or a resource pool will have more than one,
 	HostSystem string
 }
 
@@ -299,7 +326,10 @@ type Lease interface {
 	Complete() error
 }
 
-var _ lvm.VirtualMachine = (*VM)(nil)
+var (
+	_ lvm.VirtualMachine = (*VM)(nil)
+	_ lvm.AddressLister  = (*VM)(nil)
+)
 
 // VM represents a vSphere VM.
 type VM struct {
@@ -313,10 +343,18 @@ type VM struct {
 	Password string
 	// Insecure allows connecting without cert validation when set to true.
 	Insecure bool
+	// Sessions shares the vSphere session with other VMs when set, instead
+	// of logging in for every call.
+	Sessions *SessionManager
 	// Datacenter configures the datacenter onto which to import the VM.
 	Datacenter string
-	// OvfPath represents the location of the OVF file on disk.
+	// OvfPath represents the location of the OVF file on disk. It can also
+	// be an OVA, whose files are streamed into vSphere without unpacking
+	// it, or an http(s) URL of either.
 	OvfPath string
+	// OvfProperties sets the vApp properties of the OVF, such as the
+	// hostname or the keys of the VM, when the template is uploaded.
+	OvfProperties map[string]string
 	// Networks defines a mapping from each network label inside the ovf file
 	// to a vSphere network. Must be available on the host or deploy will fail.
 	Networks map[string]string
@@ -326,6 +364,16 @@ type VM struct {
 	Template string
 	// Datastores is a slice of permissible datastores. One is picked out of these.
 	Datastores []string
+	// DatastorePolicy is how the datastore is picked out of Datastores:
+	// DatastorePolicyRandom, the default, or DatastorePolicyMostFreeSpace.
+	// Datastores that are inaccessible or in maintenance mode are skipped.
+	DatastorePolicy string
+	// MinFreeSpaceMB skips the datastores with less free space than this.
+	MinFreeSpaceMB int64
+	// DatastoreCluster is the name of a datastore cluster to place the VM in
+	// as storage DRS recommends. The template is still picked out of
+	// Datastores.
+	DatastoreCluster string
 
 	// UseLocalTemplates is a flag to indicate whether a template should be uploaded on all
 	// the datastores that were passed in.
@@ -336,6 +384,56 @@ type VM struct {
 	Credentials ssh.Credentials
 	// Disks is a slice of extra disks to attach to the VM
 	Disks []Disk
+	// CloudConfig is passed to the VM through the guestinfo.userdata property
+	// when set, for cloud-init's VMware datasource to pick up.
+	CloudConfig *cloudinit.Config
+	// Customization sets the hostname, addresses and DNS servers of the
+	// guest when it's first powered on after the clone, when set.
+	Customization *Customization
+	// GuestTimeout limits how long a command run through the guest client
+	// can take. Zero lets it run for as long as it takes.
+	GuestTimeout time.Duration
+
+	// NumCPUs, CoresPerSocket and MemoryMB override the template's when set.
+	NumCPUs        int32
+	CoresPerSocket int32
+	MemoryMB       int64
+	// CPUReservation and CPULimit are in MHz, MemoryReservation and
+	// MemoryLimit in MB. A limit of -1 is unlimited, 0 keeps the template's.
+	CPUReservation    int64
+	CPULimit          int64
+	MemoryReservation int64
+	MemoryLimit       int64
+	// CPUHotAdd and MemoryHotAdd enable adding CPUs and memory to the VM
+	// while it's running.
+	CPUHotAdd    bool
+	MemoryHotAdd bool
+	// NICs is a slice of network cards to add to the VM.
+	NICs []NIC
+	// RemoveNICs are the labels of network cards of the template to remove,
+	// such as "Network adapter 1".
+	RemoveNICs []string
+	// ReplaceNICs removes all the template's network cards, so the VM only
+	// has NICs.
+	ReplaceNICs bool
+	// ExtraConfig sets advanced configuration parameters of the VM.
+	ExtraConfig map[string]string
+
+	// ShutdownTimeout makes Halt shut the guest down gracefully through
+	// VMware Tools, and power the VM off if it's still on after this long.
+	ShutdownTimeout time.Duration
+
+	// LinkedClone creates the VM as a linked clone of a snapshot of the
+	// template, which is much faster than a full clone. The VM's disks only
+	// store how they differ from the template's. Instant clones aren't
+	// supported yet, the vendored govmomi doesn't have the API for them.
+	LinkedClone bool
+	// TemplateSnapshot is the name of the template's snapshot that linked
+	// clones are created from. It's taken if the template doesn't have it,
+	// which turns the template into a VM for a moment; take it beforehand
+	// if other tools use the template too. Defaults to
+	// "libretto-linked-clone".
+	TemplateSnapshot string
 
 	uri       *url.URL
 	ctx       context.Context
@@ -362,11 +460,15 @@ func (vm *VM) Provision() (err error) {
 	}
 
 	// Upload a template to all the datastores if `UseLocalTemplates` is set.
-	// Otherwise pick a random datastore out of the list that was passed in.
+	// Otherwise pick one datastore out of the list that was passed in, as
+	// set by `DatastorePolicy`.
 	var datastores = vm.Datastores
 	if !vm.UseLocalTemplates {
-		n := util.Random(1, len(vm.Datastores))
-		datastores = []string{vm.Datastores[n-1]}
+		d, err := pickDatastore(vm, dcMo, vm.Datastores)
+		if err != nil {
+			return err
+		}
+		datastores = []string{d}
 	}
 
 	usableDatastores := []string{}
@@ -418,6 +520,19 @@ func (vm *VM) GetName() string {
 // GetIPs returns the IPs of this VM. Returns all the IPs known to the API for
 // the different network cards for this VM. Includes IPV4 and IPV6 addresses.
 func (vm *VM) GetIPs() ([]net.IP, error) {
+	addrs, err := vm.GetAddresses()
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	return lvm.AddressIPs(addrs), nil
+}
+
+// GetAddresses returns the addresses VMware Tools currently reports for each
+// network card of this VM. It does not power on the VM or wait for an IP.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
 	if err := SetupSession(vm); err != nil {
 		return nil, err
 	}
@@ -432,27 +547,7 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	if err != nil {
 		return nil, err
 	}
-	// Lazy initialized when there is an IP address later.
-	var ips []net.IP
-	for _, nic := range vmMo.Guest.Net {
-		for _, ip := range nic.IpAddress {
-			netIP := net.ParseIP(ip)
-			if netIP == nil {
-				continue
-			}
-			if ips == nil {
-				ips = make([]net.IP, 0, 1)
-			}
-			ips = append(ips, netIP)
-		}
-	}
-	if ips == nil && vmMo.Guest.IpAddress != "" {
-		ip := net.ParseIP(vmMo.Guest.IpAddress)
-		if ip != nil {
-			ips = append(ips, ip)
-		}
-	}
-	return ips, nil
+	return guestAddresses(vmMo), nil
 }
 
 // Destroy deletes this VM from vSphere.
@@ -468,15 +563,14 @@ func (vm *VM) Destroy() (err error) {
 	}
 
 	// Can't destroy a suspended VM, power it on and update the state
-	if state == "standby" {
+	if state == string(types.VirtualMachinePowerStateSuspended) {
 		err = start(vm)
 		if err != nil {
 			return err
 		}
 	}
 
-	if state != "notRunning" {
-		// Only possible states are running, shuttingDown, resetting or notRunning
+	if state != string(types.VirtualMachinePowerStatePoweredOff) {
 		timer := time.NewTimer(time.Second * 90)
 		wg := sync.WaitGroup{}
 		wg.Add(1)
@@ -491,11 +585,11 @@ func (vm *VM) Destroy() (err error) {
 					err = e
 					break
 				}
-				if state == "notRunning" {
+				if state == string(types.VirtualMachinePowerStatePoweredOff) {
 					break
 				}
 
-				if state == "running" {
+				if state == string(types.VirtualMachinePowerStatePoweredOn) {
 					e = halt(vm)
 					if e != nil {
 						err = e
@@ -555,17 +649,29 @@ func (vm *VM) GetState() (state string, err error) {
 		return "", err
 	}
 
-	if state == "running" {
+	switch types.VirtualMachinePowerState(state) {
+	case types.VirtualMachinePowerStatePoweredOn:
 		return lvm.VMRunning, nil
-	} else if state == "standby" {
+	case types.VirtualMachinePowerStateSuspended:
 		return lvm.VMSuspended, nil
-	} else if state == "shuttingDown" || state == "resetting" || state == "notRunning" {
+	case types.VirtualMachinePowerStatePoweredOff:
 		return lvm.VMHalted, nil
 	}
-	// VM state "unknown"
 	return "", lvm.ErrVMInfoFailed
 }
 
+// GetGuestState returns the state of the guest OS as reported by VMware Tools:
+// running, notRunning, shuttingDown, resetting, standby or unknown. Unlike
+// GetState, it's unknown for a running VM without Tools.
+func (vm *VM) GetGuestState() (state string, err error) {
+	if err := SetupSession(vm); err != nil {
+		return "", lvm.ErrVMInfoFailed
+	}
+	defer vm.cancel()
+
+	return getGuestState(vm)
+}
+
 // Suspend suspends this VM.
 func (vm *VM) Suspend() (err error) {
 	if err := SetupSession(vm); err != nil {
@@ -597,12 +703,16 @@ func (vm *VM) Suspend() (err error) {
 	return nil
 }
 
-// Halt halts this VM.
+// Halt halts this VM. It's powered off right away, unless ShutdownTimeout is
+// set.
 func (vm *VM) Halt() (err error) {
 	if err := SetupSession(vm); err != nil {
 		return err
 	}
 	defer vm.cancel()
+	if vm.ShutdownTimeout > 0 {
+		return shutdown(vm)
+	}
 	return halt(vm)
 }
 
@@ -620,7 +730,8 @@ func (vm *VM) Resume() (err error) {
 	return vm.Start()
 }
 
-// GetSSH returns an ssh client configured for this VM.
+// GetSSH returns an ssh client configured for this VM. The address is picked
+// by options.Target.
 func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 	ips, err := util.GetVMIPs(vm, options)
 	if err != nil {
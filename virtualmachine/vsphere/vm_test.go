@@ -3,21 +3,35 @@
 package vsphere
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/apcera/libretto/cloudinit"
+	"github.com/apcera/libretto/guest"
 	"github.com/apcera/libretto/virtualmachine"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
@@ -54,6 +68,14 @@ type mockCollector struct {
 	MockRetrieveOne func(context.Context, types.ManagedObjectReference, []string, interface{}) error
 }
 
+type mockRoundTripper struct {
+	MockRoundTrip func(context.Context, soap.HasFault, soap.HasFault) error
+}
+
+func (m mockRoundTripper) RoundTrip(c context.Context, req, res soap.HasFault) error {
+	return m.MockRoundTrip(c, req, res)
+}
+
 type mockLease struct {
 	MockLeaseProgress func(p int)
 	MockWait          func() (*types.HttpNfcLeaseInfo, error)
@@ -459,6 +481,113 @@ func TestFindComputeResourcePropertyError(t *testing.T) {
 	}
 }
 
+func TestFindResourcePool(t *testing.T) {
+	cluster := types.ManagedObjectReference{Type: "ClusterComputeResource", Value: "cluster"}
+	root := types.ManagedObjectReference{Type: "ResourcePool", Value: "root"}
+	team := types.ManagedObjectReference{Type: "ResourcePool", Value: "team"}
+	vapp := types.ManagedObjectReference{Type: "VirtualApp", Value: "vapp"}
+
+	c := mockCollector{}
+	c.MockRetrieveOne = func(c context.Context, mor types.ManagedObjectReference, ps []string, dst interface{}) error {
+		switch mor {
+		case cluster:
+			dst.(*mo.ClusterComputeResource).ResourcePool = &root
+		case root:
+			rp := dst.(*mo.ResourcePool)
+			rp.Name, rp.Owner, rp.ResourcePool = "Resources", cluster, []types.ManagedObjectReference{team}
+		case team:
+			rp := dst.(*mo.ResourcePool)
+			rp.Name, rp.Owner, rp.ResourcePool = "team-a", cluster, []types.ManagedObjectReference{vapp}
+		case vapp:
+			va := dst.(*mo.VirtualApp)
+			va.Self, va.Name, va.Owner = vapp, "ci", cluster
+		default:
+			return fmt.Errorf("Unexpected object: %s", mor)
+		}
+		return nil
+	}
+	var oldFindMob = findMob
+	defer func() {
+		findMob = oldFindMob
+	}()
+	findMob = func(vm *VM, mor types.ManagedObjectReference, name string) (*types.ManagedObjectReference, error) {
+		if name != "cluster" {
+			return nil, NewErrorObjectNotFound(fmt.Errorf("not found"), name)
+		}
+		return &cluster, nil
+	}
+	vm := &VM{collector: c}
+
+	for _, path := range []string{"cluster/team-a/ci", "/cluster/Resources/team-a/ci"} {
+		rp, err := findResourcePool(vm, &mo.Datacenter{}, path)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got: %s", path, err)
+		}
+		if rp.Reference() != vapp || rp.Owner != cluster {
+			t.Fatalf("Expected to find the vApp owned by the cluster for %q, got: %+v", path, rp)
+		}
+	}
+
+	rp, err := findResourcePool(vm, &mo.Datacenter{}, "cluster")
+	if err != nil || rp.Name != "Resources" {
+		t.Fatalf("Expected the root pool, got: %+v, %v", rp, err)
+	}
+
+	if _, err := findResourcePool(vm, &mo.Datacenter{}, "cluster/team-b"); err == nil {
+		t.Fatalf("Expected an error for a missing pool")
+	} else if _, ok := err.(ErrorObjectNotFound); !ok {
+		t.Fatalf("Expected an ErrorObjectNotFound, got: %s", err)
+	}
+}
+
+func TestFindMobNestedFolders(t *testing.T) {
+	hostFolder := types.ManagedObjectReference{Type: "Folder", Value: "host"}
+	empty := types.ManagedObjectReference{Type: "Folder", Value: "empty"}
+	nested := types.ManagedObjectReference{Type: "Folder", Value: "nested"}
+	cluster := types.ManagedObjectReference{Type: "ClusterComputeResource", Value: "cluster"}
+	root := types.ManagedObjectReference{Type: "ResourcePool", Value: "root"}
+
+	c := mockCollector{}
+	c.MockRetrieveOne = func(c context.Context, mor types.ManagedObjectReference, ps []string, dst interface{}) error {
+		switch mor {
+		case hostFolder:
+			dst.(*mo.Folder).ChildEntity = []types.ManagedObjectReference{empty, nested}
+		case empty:
+		case nested:
+			dst.(*mo.Folder).ChildEntity = []types.ManagedObjectReference{cluster}
+		case cluster:
+			switch cr := dst.(type) {
+			case *mo.ClusterComputeResource:
+				cr.Self, cr.Name, cr.ResourcePool = cluster, "cluster", &root
+			case *mo.ComputeResource:
+				cr.Self, cr.Name, cr.ResourcePool = cluster, "cluster", &root
+			}
+		case root:
+			rp := dst.(*mo.ResourcePool)
+			rp.Self, rp.Name, rp.Owner = root, "Resources", cluster
+		default:
+			return fmt.Errorf("Unexpected object: %s", mor)
+		}
+		return nil
+	}
+	vm := &VM{collector: c}
+
+	mor, err := findMob(vm, hostFolder, "cluster")
+	if err != nil || mor == nil || *mor != cluster {
+		t.Fatalf("Expected to find the cluster in the nested folder, got: %v, %v", mor, err)
+	}
+	if _, err := findMob(vm, hostFolder, "missing"); err == nil {
+		t.Fatalf("Expected an error for a missing cluster")
+	} else if _, ok := err.(ErrorObjectNotFound); !ok {
+		t.Fatalf("Expected an ErrorObjectNotFound, got: %s", err)
+	}
+
+	rp, err := findResourcePool(vm, &mo.Datacenter{HostFolder: hostFolder}, "cluster")
+	if err != nil || rp.Reference() != root {
+		t.Fatalf("Expected the root pool of the nested cluster, got: %+v, %v", rp, err)
+	}
+}
+
 func TestCreateNetworkMapping(t *testing.T) {
 	nwMap := map[string]string{
 		"nw1": "mapping1",
@@ -736,3 +865,919 @@ func TestCreateRequestHappyPath(t *testing.T) {
 		t.Fatalf("Expected to get no errors got: %s", err)
 	}
 }
+
+func TestCloudInitExtraConfig(t *testing.T) {
+	vm := &VM{Name: "test", CloudConfig: &cloudinit.Config{Packages: []string{"curl"}}}
+	opts, err := cloudInitExtraConfig(vm)
+	if err != nil {
+		t.Fatalf("Expected to get no error, got: %s", err)
+	}
+	values := map[string]string{}
+	for _, o := range opts {
+		ov := o.GetOptionValue()
+		values[ov.Key] = ov.Value.(string)
+	}
+	userData, err := base64.StdEncoding.DecodeString(values["guestinfo.userdata"])
+	if err != nil {
+		t.Fatalf("Expected base64 user data, got: %s", err)
+	}
+	if !strings.HasPrefix(string(userData), cloudinit.Header) {
+		t.Fatalf("Expected a cloud config, got: %s", userData)
+	}
+	if values["guestinfo.userdata.encoding"] != "base64" || values["guestinfo.metadata.encoding"] != "base64" {
+		t.Fatalf("Expected base64 encodings, got: %v", values)
+	}
+	metaData, _ := base64.StdEncoding.DecodeString(values["guestinfo.metadata"])
+	if !strings.Contains(string(metaData), "local-hostname: test") {
+		t.Fatalf("Expected the VM name as hostname, got: %s", metaData)
+	}
+}
+
+func TestCustomizationSpec(t *testing.T) {
+	vm := &VM{Name: "test", Customization: &Customization{
+		Domain:     "example.com",
+		DNSServers: []string{"10.0.0.2"},
+		NICs: []NICCustomization{
+			{IP: "10.0.0.10", Netmask: "255.255.255.0", Gateway: []string{"10.0.0.1"}, IPv6: []string{"2001:db8::10/64"}},
+		},
+	}}
+	spec, err := customizationSpec(vm, 2)
+	if err != nil {
+		t.Fatalf("Expected to get no error, got: %s", err)
+	}
+	prep := spec.Identity.(*types.CustomizationLinuxPrep)
+	if prep.HostName.(*types.CustomizationFixedName).Name != "test" || prep.Domain != "example.com" || !*prep.HwClockUTC {
+		t.Fatalf("Unexpected identity: %+v", prep)
+	}
+	if len(spec.GlobalIPSettings.DnsServerList) != 1 || len(spec.NicSettingMap) != 2 {
+		t.Fatalf("Unexpected spec: %+v", spec)
+	}
+	nic := spec.NicSettingMap[0].Adapter
+	if nic.Ip.(*types.CustomizationFixedIp).IpAddress != "10.0.0.10" || nic.SubnetMask != "255.255.255.0" {
+		t.Fatalf("Expected a static IP on the first card, got: %+v", nic)
+	}
+	if v6 := nic.IpV6Spec.Ip[0].(*types.CustomizationFixedIpV6); v6.IpAddress != "2001:db8::10" || v6.SubnetMask != 64 {
+		t.Fatalf("Expected a static IPv6 address on the first card, got: %+v", v6)
+	}
+	if _, ok := spec.NicSettingMap[1].Adapter.Ip.(*types.CustomizationDhcpIpGenerator); !ok {
+		t.Fatalf("Expected DHCP on the second card, got: %+v", spec.NicSettingMap[1].Adapter)
+	}
+
+	if _, err := customizationSpec(vm, 0); err == nil {
+		t.Fatalf("Expected an error with more NIC settings than cards")
+	}
+
+	var oldGetCustomizationSpec = getCustomizationSpec
+	defer func() {
+		getCustomizationSpec = oldGetCustomizationSpec
+	}()
+	getCustomizationSpec = func(vm *VM, name string) (*types.CustomizationSpec, error) {
+		return &types.CustomizationSpec{
+			Identity: &types.CustomizationLinuxPrep{
+				HostName: &types.CustomizationPrefixName{Base: "web"},
+				TimeZone: "UTC",
+			},
+			NicSettingMap: []types.CustomizationAdapterMapping{{}},
+		}, nil
+	}
+	vm.Customization = &Customization{SpecName: "saved", TimeZone: "Europe/Paris"}
+	spec, err = customizationSpec(vm, 1)
+	if err != nil {
+		t.Fatalf("Expected to get no error, got: %s", err)
+	}
+	prep = spec.Identity.(*types.CustomizationLinuxPrep)
+	if _, ok := prep.HostName.(*types.CustomizationPrefixName); !ok || prep.TimeZone != "Europe/Paris" {
+		t.Fatalf("Expected the saved hostname and an overridden time zone, got: %+v", prep)
+	}
+}
+
+func TestHardwareSpec(t *testing.T) {
+	if spec := hardwareSpec(&VM{}); !reflect.DeepEqual(spec, types.VirtualMachineConfigSpec{}) {
+		t.Fatalf("Expected an empty spec, got: %+v", spec)
+	}
+
+	vm := &VM{
+		NumCPUs:     4,
+		MemoryMB:    8192,
+		CPUHotAdd:   true,
+		MemoryLimit: -1,
+		ExtraConfig: map[string]string{"b": "2", "a": "1"},
+	}
+	spec := hardwareSpec(vm)
+	if spec.NumCPUs != 4 || spec.MemoryMB != 8192 || !*spec.CpuHotAddEnabled || spec.MemoryHotAddEnabled != nil {
+		t.Fatalf("Unexpected hardware settings: %+v", spec)
+	}
+	if spec.CpuAllocation != nil || spec.MemoryAllocation.(*types.ResourceAllocationInfo).Limit != -1 {
+		t.Fatalf("Expected only a memory allocation, got: %+v, %+v", spec.CpuAllocation, spec.MemoryAllocation)
+	}
+	if len(spec.ExtraConfig) != 2 || spec.ExtraConfig[0].GetOptionValue().Key != "a" {
+		t.Fatalf("Expected sorted extra config, got: %+v", spec.ExtraConfig)
+	}
+}
+
+func TestDeviceChanges(t *testing.T) {
+	var oldNicBacking = nicBacking
+	defer func() {
+		nicBacking = oldNicBacking
+	}()
+	nicBacking = func(vm *VM, networks []types.ManagedObjectReference, name string) (types.BaseVirtualDeviceBackingInfo, error) {
+		if name != "private" {
+			return nil, NewErrorObjectNotFound(errors.New("could not find the network"), name)
+		}
+		return &types.VirtualEthernetCardNetworkBackingInfo{}, nil
+	}
+
+	devices := object.VirtualDeviceList{
+		&types.VirtualLsiLogicController{VirtualSCSIController: types.VirtualSCSIController{
+			VirtualController: types.VirtualController{VirtualDevice: types.VirtualDevice{Key: 1000}},
+		}},
+		&types.VirtualE1000{VirtualEthernetCard: types.VirtualEthernetCard{VirtualDevice: types.VirtualDevice{Key: 4000}}},
+	}
+	vm := &VM{
+		Disks:       []Disk{{Size: 1024, Controller: "scsi"}, {Size: 2048, Controller: "scsi"}},
+		NICs:        []NIC{{Network: "private", Type: "vmxnet3", MAC: "00:50:56:00:00:01"}},
+		ReplaceNICs: true,
+	}
+	changes, err := deviceChanges(vm, devices, nil)
+	if err != nil {
+		t.Fatalf("Expected to get no error, got: %s", err)
+	}
+	if len(changes) != 4 {
+		t.Fatalf("Expected 2 disks, a removed and an added card, got: %d changes", len(changes))
+	}
+	d1 := changes[0].GetVirtualDeviceConfigSpec().Device.GetVirtualDevice()
+	d2 := changes[1].GetVirtualDeviceConfigSpec().Device.GetVirtualDevice()
+	if d1.Key == d2.Key || d1.ControllerKey != 1000 {
+		t.Fatalf("Expected distinct disks, got: %+v, %+v", d1, d2)
+	}
+	if rm := changes[2].GetVirtualDeviceConfigSpec(); rm.Operation != types.VirtualDeviceConfigSpecOperationRemove || rm.Device.GetVirtualDevice().Key != 4000 {
+		t.Fatalf("Expected the template card to be removed, got: %+v", rm)
+	}
+	card, ok := changes[3].GetVirtualDeviceConfigSpec().Device.(*types.VirtualVmxnet3)
+	if !ok || card.MacAddress != "00:50:56:00:00:01" || card.AddressType != string(types.VirtualEthernetCardMacTypeManual) {
+		t.Fatalf("Expected a vmxnet3 card with a manual MAC, got: %+v", changes[3].GetVirtualDeviceConfigSpec().Device)
+	}
+
+	vm = &VM{NICs: []NIC{{Network: "public"}}}
+	if _, err := deviceChanges(vm, devices, nil); err == nil {
+		t.Fatalf("Expected an error for a missing network")
+	}
+
+	// Without a type, the card is a vmxnet3 rather than govmomi's e1000.
+	vm = &VM{NICs: []NIC{{Network: "private"}}}
+	changes, err = deviceChanges(vm, devices, nil)
+	if err != nil {
+		t.Fatalf("Expected to get no error, got: %s", err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("Expected an added card, got: %d changes", len(changes))
+	}
+	if _, ok := changes[0].GetVirtualDeviceConfigSpec().Device.(*types.VirtualVmxnet3); !ok {
+		t.Fatalf("Expected a vmxnet3 card by default, got: %+v", changes[0].GetVirtualDeviceConfigSpec().Device)
+	}
+}
+
+func TestDeviceChangesRemoveNICs(t *testing.T) {
+	card := func(key int, label string) types.BaseVirtualDevice {
+		return &types.VirtualVmxnet3{VirtualVmxnet: types.VirtualVmxnet{VirtualEthernetCard: types.VirtualEthernetCard{
+			VirtualDevice: types.VirtualDevice{Key: key, DeviceInfo: &types.Description{Label: label}},
+		}}}
+	}
+	devices := object.VirtualDeviceList{card(4000, "Network adapter 1"), card(4001, "Network adapter 2")}
+
+	vm := &VM{RemoveNICs: []string{"Network adapter 2"}}
+	changes, err := deviceChanges(vm, devices, nil)
+	if err != nil {
+		t.Fatalf("Expected to get no error, got: %s", err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("Expected a removed card, got: %d changes", len(changes))
+	}
+	if rm := changes[0].GetVirtualDeviceConfigSpec(); rm.Operation != types.VirtualDeviceConfigSpecOperationRemove || rm.Device.GetVirtualDevice().Key != 4001 {
+		t.Fatalf("Expected the second card to be removed, got: %+v", rm)
+	}
+
+	vm = &VM{RemoveNICs: []string{"Network adapter 3"}}
+	if _, err := deviceChanges(vm, devices, nil); err == nil {
+		t.Fatalf("Expected an error for a missing network card")
+	}
+}
+
+func TestFindSnapshot(t *testing.T) {
+	tree := []types.VirtualMachineSnapshotTree{
+		{Name: "base", Snapshot: types.ManagedObjectReference{Value: "1"}, ChildSnapshotList: []types.VirtualMachineSnapshotTree{
+			{Name: "libretto-linked-clone", Snapshot: types.ManagedObjectReference{Value: "2"}},
+		}},
+	}
+	if s, err := findSnapshot(tree, "libretto-linked-clone"); err != nil || s == nil || s.Value != "2" {
+		t.Fatalf("Expected to find the child snapshot, got: %v, %v", s, err)
+	}
+	if s, err := findSnapshot(tree, "missing"); err != nil || s != nil {
+		t.Fatalf("Expected no snapshot, got: %v, %v", s, err)
+	}
+
+	tree[0].ChildSnapshotList[0].ChildSnapshotList = []types.VirtualMachineSnapshotTree{
+		{Name: "base", Snapshot: types.ManagedObjectReference{Value: "3"}},
+	}
+	if s, err := findSnapshot(tree, "base"); err != ErrorAmbiguousSnapshot {
+		t.Fatalf("Expected ErrorAmbiguousSnapshot for a duplicate name, got: %v, %v", s, err)
+	}
+}
+
+func TestWaitTemplateSnapshot(t *testing.T) {
+	var oldWait, oldPoll = templateSnapshotWait, templateSnapshotPoll
+	defer func() {
+		templateSnapshotWait, templateSnapshotPoll = oldWait, oldPoll
+	}()
+	templateSnapshotWait, templateSnapshotPoll = time.Second, time.Millisecond
+
+	polls := 0
+	c := mockCollector{}
+	c.MockRetrieveOne = func(_ context.Context, _ types.ManagedObjectReference, _ []string, dst interface{}) error {
+		polls++
+		if polls == 3 {
+			dst.(*mo.VirtualMachine).Snapshot = &types.VirtualMachineSnapshotInfo{
+				RootSnapshotList: []types.VirtualMachineSnapshotTree{
+					{Name: "libretto-linked-clone", Snapshot: types.ManagedObjectReference{Value: "1"}},
+				},
+			}
+		}
+		return nil
+	}
+	vm := &VM{Template: "template", collector: c}
+	vmObj := object.NewVirtualMachine(nil, types.ManagedObjectReference{Value: "template"})
+	template := createTemplateName(vm.Template, "datastore1")
+	if s, err := waitTemplateSnapshot(vm, vmObj, template, "libretto-linked-clone"); err != nil || s.Value != "1" {
+		t.Fatalf("Expected the snapshot taken meanwhile, got: %v, %v", s, err)
+	}
+
+	templateSnapshotWait = 0
+	if _, err := waitTemplateSnapshot(vm, vmObj, template, "missing"); err == nil {
+		t.Fatalf("Expected an error when the snapshot isn't taken in time")
+	} else if !strings.Contains(err.Error(), template) {
+		t.Fatalf("Expected the error to name the template %s, got: %s", template, err)
+	}
+}
+
+func TestLockTemplate(t *testing.T) {
+	ref := types.ManagedObjectReference{Value: "template"}
+	unlock := lockTemplate("1.1.1.1", ref)
+	// Other templates and hosts aren't locked.
+	lockTemplate("1.1.1.1", types.ManagedObjectReference{Value: "other"})()
+	lockTemplate("2.2.2.2", ref)()
+
+	locked := make(chan struct{})
+	go func() {
+		lockTemplate("1.1.1.1", ref)()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("Expected the template to stay locked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
+
+func TestIsInvalidState(t *testing.T) {
+	fault := &soap.Fault{}
+	fault.Detail.Fault = types.InvalidState{}
+	if !isInvalidState(soap.WrapSoapFault(fault)) {
+		t.Fatalf("Expected an InvalidState fault to be detected")
+	}
+	if isInvalidState(errors.New("InvalidState")) || isInvalidState(nil) {
+		t.Fatalf("Expected other errors not to be detected")
+	}
+}
+
+func TestSnapshotTree(t *testing.T) {
+	current := types.ManagedObjectReference{Type: "VirtualMachineSnapshot", Value: "2"}
+	info := &types.VirtualMachineSnapshotInfo{
+		CurrentSnapshot: &current,
+		RootSnapshotList: []types.VirtualMachineSnapshotTree{
+			{Name: "clean", Snapshot: types.ManagedObjectReference{Type: "VirtualMachineSnapshot", Value: "1"}, State: types.VirtualMachinePowerStatePoweredOff, ChildSnapshotList: []types.VirtualMachineSnapshotTree{
+				{Name: "configured", Snapshot: current, Quiesced: true},
+			}},
+		},
+	}
+	snapshots := snapshotTree(info.RootSnapshotList, info.CurrentSnapshot)
+	if len(snapshots) != 1 || snapshots[0].Name != "clean" || snapshots[0].Current || snapshots[0].State != "poweredOff" {
+		t.Fatalf("Unexpected root snapshot: %+v", snapshots)
+	}
+	if c := snapshots[0].Children; len(c) != 1 || c[0].Name != "configured" || !c[0].Current || !c[0].Quiesced {
+		t.Fatalf("Unexpected child snapshot: %+v", c)
+	}
+
+	var oldSnapshotInfo = snapshotInfo
+	defer func() {
+		snapshotInfo = oldSnapshotInfo
+	}()
+	snapshotInfo = func(vm *VM) (*object.VirtualMachine, *types.VirtualMachineSnapshotInfo, error) {
+		return nil, info, nil
+	}
+	if s, err := findVMSnapshot(&VM{}, "configured"); err != nil || s != current {
+		t.Fatalf("Expected to find the current snapshot, got: %v, %v", s, err)
+	}
+	if _, err := findVMSnapshot(&VM{}, "missing"); err == nil {
+		t.Fatalf("Expected an error for a missing snapshot")
+	} else if _, ok := err.(ErrorObjectNotFound); !ok {
+		t.Fatalf("Expected an ErrorObjectNotFound, got: %s", err)
+	}
+}
+
+func TestGetStateWithoutTools(t *testing.T) {
+	f := mockFinder{}
+	f.MockDatacenterList = func(context.Context, string) ([]*object.Datacenter, error) {
+		return []*object.Datacenter{{}}, nil
+	}
+	c := mockCollector{}
+	c.MockRetrieveOne = func(_ context.Context, _ types.ManagedObjectReference, _ []string, dst interface{}) error {
+		dst.(*mo.Datacenter).Name = "test-dc"
+		return nil
+	}
+	var oldFindVM = findVM
+	defer func() {
+		findVM = oldFindVM
+	}()
+	findVM = func(vm *VM, dc *mo.Datacenter, name string) (*mo.VirtualMachine, error) {
+		vmMo := &mo.VirtualMachine{}
+		vmMo.Runtime.PowerState = types.VirtualMachinePowerStatePoweredOn
+		return vmMo, nil
+	}
+	vm := &VM{Name: "test", Datacenter: "test-dc", finder: f, collector: c}
+
+	state, err := getState(vm)
+	if err != nil || state != string(types.VirtualMachinePowerStatePoweredOn) {
+		t.Fatalf("Expected the VM to be powered on, got: %q, %v", state, err)
+	}
+	state, err = getGuestState(vm)
+	if err != nil || state != "unknown" {
+		t.Fatalf("Expected an unknown guest state, got: %q, %v", state, err)
+	}
+}
+
+func TestSessionManager(t *testing.T) {
+	var oldNewSessionClient = newSessionClient
+	var oldLogoutSession = logoutSession
+	defer func() {
+		newSessionClient = oldNewSessionClient
+		logoutSession = oldLogoutSession
+	}()
+	logins, logouts := 0, 0
+	newSessionClient = func(u *url.URL, insecure bool, keepAlive time.Duration) (*govmomi.Client, error) {
+		logins++
+		if keepAlive != defaultKeepAlive {
+			t.Fatalf("Expected the default keep alive, got: %s", keepAlive)
+		}
+		return &govmomi.Client{Client: &vim25.Client{}}, nil
+	}
+	logoutSession = func(c *govmomi.Client) error {
+		logouts++
+		return nil
+	}
+
+	m := NewSessionManager()
+	vm1 := &VM{Host: "1.1.1.1", Username: "root", Password: "test", Sessions: m}
+	vm2 := &VM{Host: "1.1.1.1", Username: "root", Password: "test", Sessions: m}
+	vm3 := &VM{Host: "1.1.1.1", Username: "admin", Password: "test", Sessions: m}
+	for _, vm := range []*VM{vm1, vm2, vm3, vm1} {
+		if err := SetupSession(vm); err != nil {
+			t.Fatalf("Unexpected error setting up the session: %s", err)
+		}
+		vm.cancel()
+		vm.cancel()
+	}
+	if logins != 2 || logouts != 0 {
+		t.Fatalf("Expected one session per user, got %d logins and %d logouts", logins, logouts)
+	}
+
+	if err := SetupSession(vm2); err != nil {
+		t.Fatalf("Unexpected error setting up the session: %s", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Unexpected error closing the sessions: %s", err)
+	}
+	if logouts != 1 {
+		t.Fatalf("Expected only the idle session to be logged out, got %d logouts", logouts)
+	}
+	vm2.cancel()
+	if logouts != 2 {
+		t.Fatalf("Expected the session to be logged out once released, got %d logouts", logouts)
+	}
+}
+
+func TestSessionManagerConcurrentLogin(t *testing.T) {
+	var oldNewSessionClient = newSessionClient
+	var oldLogoutSession = logoutSession
+	defer func() {
+		newSessionClient = oldNewSessionClient
+		logoutSession = oldLogoutSession
+	}()
+	var mu sync.Mutex
+	logins := 0
+	blocked := make(chan struct{})
+	unblock := make(chan struct{})
+	newSessionClient = func(u *url.URL, insecure bool, keepAlive time.Duration) (*govmomi.Client, error) {
+		mu.Lock()
+		logins++
+		mu.Unlock()
+		if u.User.Username() == "root" {
+			close(blocked)
+			<-unblock
+		}
+		return &govmomi.Client{Client: &vim25.Client{}}, nil
+	}
+	logoutSession = func(c *govmomi.Client) error {
+		return errors.New("logout failed")
+	}
+
+	m := NewSessionManager()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vm := &VM{Host: "1.1.1.1", Username: "root", Password: "test", Sessions: m}
+			if err := SetupSession(vm); err != nil {
+				t.Errorf("Unexpected error setting up the session: %s", err)
+				return
+			}
+			vm.cancel()
+		}()
+		if i == 0 {
+			<-blocked
+		}
+	}
+
+	// Other users can log in while root's login is in flight.
+	vm := &VM{Host: "1.1.1.1", Username: "admin", Password: "test", Sessions: m}
+	if err := SetupSession(vm); err != nil {
+		t.Fatalf("Unexpected error setting up the session: %s", err)
+	}
+	close(unblock)
+	wg.Wait()
+	if logins != 2 {
+		t.Fatalf("Expected one login per user, got %d", logins)
+	}
+
+	if err := m.Close(); err == nil || err.Error() != "logout failed" {
+		t.Fatalf("Expected the logout error, got: %v", err)
+	}
+	vm.cancel()
+	if err := m.Close(); err == nil || err.Error() != "logout failed" {
+		t.Fatalf("Expected the logout error of the released session, got: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Expected no error once all sessions are logged out, got: %s", err)
+	}
+}
+
+func TestSessionManagerLoginError(t *testing.T) {
+	var oldNewSessionClient = newSessionClient
+	defer func() {
+		newSessionClient = oldNewSessionClient
+	}()
+	logins := 0
+	newSessionClient = func(u *url.URL, insecure bool, keepAlive time.Duration) (*govmomi.Client, error) {
+		logins++
+		if logins == 1 {
+			return nil, errors.New("login failed")
+		}
+		return &govmomi.Client{Client: &vim25.Client{}}, nil
+	}
+
+	m := NewSessionManager()
+	vm := &VM{Host: "1.1.1.1", Username: "root", Password: "test", Sessions: m}
+	if err := SetupSession(vm); err == nil {
+		t.Fatalf("Expected the login error")
+	}
+	if err := SetupSession(vm); err != nil {
+		t.Fatalf("Expected the failed login not to be shared, got: %s", err)
+	}
+	vm.cancel()
+	if logins != 2 {
+		t.Fatalf("Expected 2 logins, got %d", logins)
+	}
+}
+
+func TestReloginRoundTripper(t *testing.T) {
+	var mu sync.Mutex
+	loggedIn, logins := false, 0
+	rt := mockRoundTripper{}
+	rt.MockRoundTrip = func(_ context.Context, req, res soap.HasFault) error {
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.(type) {
+		case *methods.LoginBody:
+			logins++
+			loggedIn = true
+			res.(*methods.LoginBody).Res = &types.LoginResponse{}
+			return nil
+		case *methods.CurrentTimeBody:
+			if !loggedIn {
+				fault := &soap.Fault{}
+				fault.Detail.Fault = types.NotAuthenticated{}
+				res.(*methods.CurrentTimeBody).Fault_ = fault
+				return soap.WrapSoapFault(fault)
+			}
+			res.(*methods.CurrentTimeBody).Res = &types.CurrentTimeResponse{Returnval: time.Now()}
+			return nil
+		}
+		return fmt.Errorf("Unexpected request: %T", req)
+	}
+	client := &vim25.Client{ServiceContent: types.ServiceContent{SessionManager: &types.ManagedObjectReference{}}}
+	client.RoundTripper = &reloginRoundTripper{RoundTripper: rt, client: client, user: url.UserPassword("root", "test")}
+
+	// All the requests failing because the session expired log in once.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := methods.CurrentTime(context.Background(), client, &types.CurrentTime{})
+			if err != nil || res == nil || res.Returnval.IsZero() {
+				t.Errorf("Expected the request to be retried, got: %v, %v", res, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if logins != 1 {
+		t.Fatalf("Expected to log in once, got %d logins", logins)
+	}
+
+	mu.Lock()
+	loggedIn = false
+	mu.Unlock()
+	if _, err := methods.CurrentTime(context.Background(), client, &types.CurrentTime{}); err != nil {
+		t.Fatalf("Expected the request to be retried, got: %s", err)
+	}
+	if logins != 2 {
+		t.Fatalf("Expected to log in again once the session expired again, got %d logins", logins)
+	}
+}
+
+func TestKeepAliveRoundTripper(t *testing.T) {
+	sent := make(chan struct{}, 1)
+	rt := mockRoundTripper{}
+	rt.MockRoundTrip = func(_ context.Context, req, res soap.HasFault) error {
+		if _, ok := req.(*methods.CurrentTimeBody); !ok {
+			return fmt.Errorf("Unexpected request: %T", req)
+		}
+		select {
+		case sent <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	k := newKeepAliveRoundTripper(rt, 10*time.Millisecond)
+	k.start(k)
+	defer k.stop()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected an authenticated request to keep the session alive")
+	}
+}
+
+func TestIsNotAuthenticated(t *testing.T) {
+	fault := &soap.Fault{}
+	fault.Detail.Fault = types.NotAuthenticated{}
+	if !isNotAuthenticated(soap.WrapSoapFault(fault)) {
+		t.Fatalf("Expected a NotAuthenticated fault to be detected")
+	}
+	if isNotAuthenticated(errors.New("NotAuthenticated")) || isNotAuthenticated(nil) {
+		t.Fatalf("Expected other errors not to be detected")
+	}
+}
+
+func TestSelectDatastore(t *testing.T) {
+	ds := func(name string, freeMB int64, mode string) mo.Datastore {
+		d := mo.Datastore{Summary: types.DatastoreSummary{Accessible: true, FreeSpace: freeMB * 1024 * 1024, MaintenanceMode: mode}}
+		d.Name = name
+		return d
+	}
+	candidates := []mo.Datastore{
+		ds("small", 100, "normal"),
+		ds("large", 5000, "normal"),
+		ds("maintenance", 9000, "inMaintenance"),
+		ds("medium", 1000, ""),
+	}
+
+	vm := &VM{DatastorePolicy: DatastorePolicyMostFreeSpace}
+	if name, err := selectDatastore(vm, candidates); err != nil || name != "large" {
+		t.Fatalf("Expected the datastore with the most free space, got: %q, %v", name, err)
+	}
+
+	vm = &VM{MinFreeSpaceMB: 500}
+	for i := 0; i < 10; i++ {
+		name, err := selectDatastore(vm, candidates)
+		if err != nil || (name != "large" && name != "medium") {
+			t.Fatalf("Expected a datastore with enough free space, got: %q, %v", name, err)
+		}
+	}
+
+	vm = &VM{MinFreeSpaceMB: 10000}
+	if _, err := selectDatastore(vm, candidates); err != ErrorNoDatastore {
+		t.Fatalf("Expected ErrorNoDatastore, got: %v", err)
+	}
+}
+
+func TestLeastLoadedHost(t *testing.T) {
+	hw := &types.HostHardwareSummary{CpuMhz: 2000, NumCpuCores: 4, MemorySize: 16 * 1024 * 1024 * 1024}
+	connected := &types.HostRuntimeInfo{ConnectionState: types.HostSystemConnectionStateConnected}
+	summaries := map[string]types.HostListSummary{
+		"busy":        {Hardware: hw, Runtime: connected, QuickStats: types.HostListSummaryQuickStats{OverallCpuUsage: 6000, OverallMemoryUsage: 1024}},
+		"idle":        {Hardware: hw, Runtime: connected, QuickStats: types.HostListSummaryQuickStats{OverallCpuUsage: 800, OverallMemoryUsage: 4096}},
+		"maintenance": {Hardware: hw, Runtime: &types.HostRuntimeInfo{ConnectionState: types.HostSystemConnectionStateConnected, InMaintenanceMode: true}},
+	}
+	c := mockCollector{}
+	c.MockRetrieveOne = func(_ context.Context, mor types.ManagedObjectReference, _ []string, dst interface{}) error {
+		dst.(*mo.HostSystem).Summary = summaries[mor.Value]
+		return nil
+	}
+	vm := &VM{collector: c}
+	hosts := []types.ManagedObjectReference{{Value: "busy"}, {Value: "maintenance"}, {Value: "idle"}}
+	host, err := leastLoadedHost(vm, hosts)
+	if err != nil || host.Value != "idle" {
+		t.Fatalf("Expected the idle host, got: %v, %v", host, err)
+	}
+	if _, err := leastLoadedHost(vm, hosts[1:2]); err == nil {
+		t.Fatalf("Expected an error when all hosts are in maintenance mode")
+	}
+}
+
+func TestRecommendedDatastore(t *testing.T) {
+	ds := types.ManagedObjectReference{Type: "Datastore", Value: "ds-1"}
+	result := &types.StoragePlacementResult{Recommendations: []types.ClusterRecommendation{
+		{Action: []types.BaseClusterAction{&types.StoragePlacementAction{Destination: ds}}},
+	}}
+	if d := recommendedDatastore(result); d == nil || *d != ds {
+		t.Fatalf("Expected the recommended datastore, got: %v", d)
+	}
+	if d := recommendedDatastore(&types.StoragePlacementResult{}); d != nil {
+		t.Fatalf("Expected no datastore, got: %v", d)
+	}
+}
+
+func TestDownloadDisksAndWriteOVA(t *testing.T) {
+	var oldDownloadFile = downloadFile
+	defer func() {
+		downloadFile = oldDownloadFile
+	}()
+	var urls []string
+	downloadFile = func(vm *VM, rawURL string, w io.Writer) (int64, error) {
+		urls = append(urls, rawURL)
+		n, err := io.WriteString(w, "vmdk data")
+		return int64(n), err
+	}
+
+	dir, err := ioutil.TempDir("", "libretto-export-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	notDisk := false
+	leaseInfo := &types.HttpNfcLeaseInfo{DeviceUrl: []types.HttpNfcLeaseDeviceUrl{
+		{Key: "/vm-1/VirtualLsiLogicController0:0", Url: "https://*/nfc/52a/disk-0.vmdk"},
+		{Key: "/vm-1/nvram", Url: "https://*/nfc/52a/nvram", Disk: &notDisk},
+	}}
+	files, err := downloadDisks(&VM{Host: "vc.example.com"}, dir, leaseInfo, mockLease{})
+	if err != nil {
+		t.Fatalf("Expected to get no error, got: %s", err)
+	}
+	if len(urls) != 1 || urls[0] != "https://vc.example.com/nfc/52a/disk-0.vmdk" {
+		t.Fatalf("Expected only the disk to be downloaded from the host, got: %v", urls)
+	}
+	if len(files) != 1 || files[0].Path != "disk-0.vmdk" || files[0].Size != 9 || files[0].DeviceId != leaseInfo.DeviceUrl[0].Key {
+		t.Fatalf("Unexpected OVF files: %+v", files)
+	}
+
+	var buf bytes.Buffer
+	if err := writeOVA(&buf, "test.ovf", "<Envelope/>", dir, files); err != nil {
+		t.Fatalf("Expected to get no error, got: %s", err)
+	}
+	tr := tar.NewReader(&buf)
+	for _, want := range []string{"test.ovf", "disk-0.vmdk"} {
+		h, err := tr.Next()
+		if err != nil || h.Name != want {
+			t.Fatalf("Expected %s in the OVA, got: %+v, %v", want, h, err)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("Expected the end of the OVA, got: %v", err)
+	}
+}
+
+func TestParseAndUploadOva(t *testing.T) {
+	var oldCreateRequest = createRequest
+	defer func() {
+		createRequest = oldCreateRequest
+	}()
+	type upload struct {
+		method, url, data, contentType string
+		length                         int64
+	}
+	var uploads []upload
+	createRequest = func(r io.Reader, method string, insecure bool, length int64, url string, contentType string) error {
+		b, err := ioutil.ReadAll(r)
+		uploads = append(uploads, upload{method, url, string(b), contentType, length})
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "libretto-ova-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{"disk-0.vmdk": "vmdk data", "test.mf": "manifest"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ova, err := os.Create(filepath.Join(dir, "test.ova"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []types.OvfFile{{Path: "test.mf", Size: 8}, {Path: "disk-0.vmdk", Size: 9}}
+	err = writeOVA(ova, "test.ovf", "<Envelope/>", dir, files)
+	ova.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &VM{Host: "vc.example.com", OvfPath: ova.Name()}
+	descriptor, err := parseOvf(vm.OvfPath)
+	if err != nil || descriptor != "<Envelope/>" {
+		t.Fatalf("Expected to get the descriptor out of the OVA, got: %q, %v", descriptor, err)
+	}
+
+	completed := false
+	lease := mockLease{MockComplete: func() error {
+		completed = true
+		return nil
+	}}
+	leaseInfo := &types.HttpNfcLeaseInfo{DeviceUrl: []types.HttpNfcLeaseDeviceUrl{
+		{ImportKey: "/test/disk-0", Url: "https://*/nfc/52a/disk-0.vmdk"},
+	}}
+	specResult := &types.OvfCreateImportSpecResult{FileItem: []types.OvfFileItem{
+		{DeviceId: "/test/disk-0", Path: "disk-0.vmdk", Size: 9},
+	}}
+	if err := uploadOva(vm, specResult, leaseInfo, lease); err != nil {
+		t.Fatalf("Expected to get no error, got: %s", err)
+	}
+	want := []upload{{"POST", "https://vc.example.com/nfc/52a/disk-0.vmdk", "vmdk data", "application/x-vnd.vmware-streamVmdk", 9}}
+	if !reflect.DeepEqual(uploads, want) {
+		t.Fatalf("Expected only the disk to be uploaded, got: %+v", uploads)
+	}
+	if !completed {
+		t.Fatal("Expected the lease to be completed")
+	}
+
+	specResult.FileItem[0].Path = "disk-1.vmdk"
+	if err := uploadOva(vm, specResult, leaseInfo, lease); err == nil {
+		t.Fatal("Expected to get an error for a file missing from the OVA")
+	}
+}
+
+func TestUploadContentType(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"disk-0.vmdk", "application/x-vnd.vmware-streamVmdk"},
+		{"DISK-0.VMDK", "application/x-vnd.vmware-streamVmdk"},
+		{"cdrom.iso", "application/octet-stream"},
+		{"nvram", "application/octet-stream"},
+	}
+	for _, test := range tests {
+		if got := uploadContentType(test.name); got != test.want {
+			t.Fatalf("Expected %q for %s, got: %q", test.want, test.name, got)
+		}
+	}
+}
+
+func TestOvfFileLocation(t *testing.T) {
+	tests := []struct {
+		location, name, want string
+	}{
+		{"/tmp/ovf/test.ovf", "disk-0.vmdk", "/tmp/ovf/disk-0.vmdk"},
+		{"/tmp/ovf/test.ovf", "/data/disk-0.vmdk", "/data/disk-0.vmdk"},
+		{"https://build.example.com/ovf/test.ovf?v=1", "disk-0.vmdk", "https://build.example.com/ovf/disk-0.vmdk"},
+	}
+	for _, tt := range tests {
+		got, err := ovfFileLocation(tt.location, tt.name)
+		if err != nil || got != tt.want {
+			t.Fatalf("Expected %s for %s, got: %s, %v", tt.want, tt.name, got, err)
+		}
+	}
+	if !isOva("https://build.example.com/test.OVA?v=1") || isOva("/tmp/ovf/test.ovf") {
+		t.Fatal("Expected only the OVA to be detected as one")
+	}
+}
+
+func TestPropertyMapping(t *testing.T) {
+	if m := propertyMapping(nil); m != nil {
+		t.Fatalf("Expected no property mapping, got: %+v", m)
+	}
+	m := propertyMapping(map[string]string{"hostname": "web-1", "public-keys": "ssh-rsa AAAA"})
+	want := []types.KeyValue{{Key: "hostname", Value: "web-1"}, {Key: "public-keys", Value: "ssh-rsa AAAA"}}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("Expected %+v, got: %+v", want, m)
+	}
+}
+
+func TestGuestAddresses(t *testing.T) {
+	vmMo := &mo.VirtualMachine{
+		Guest: &types.GuestInfo{
+			Net: []types.GuestNicInfo{
+				{DeviceConfigId: 4000, MacAddress: "00:50:56:00:00:01", IpAddress: []string{"10.0.0.5"}},
+				{DeviceConfigId: -1, IpAddress: []string{"172.17.0.1"}},
+			},
+		},
+	}
+	addrs := guestAddresses(vmMo)
+	if len(addrs) != 2 {
+		t.Fatalf("Expected 2 addresses, got: %+v", addrs)
+	}
+	if addrs[0].Interface != "4000" || addrs[0].MAC != "00:50:56:00:00:01" {
+		t.Fatalf("Expected the device key as the interface, got: %+v", addrs[0])
+	}
+	if addrs[1].Interface != "" || addrs[1].NIC != 1 {
+		t.Fatalf("Expected no interface for an unmapped NIC, got: %+v", addrs[1])
+	}
+}
+
+type mockProcessLister struct {
+	MockListProcesses func(context.Context, types.BaseGuestAuthentication, []int64) ([]types.GuestProcessInfo, error)
+}
+
+func (m mockProcessLister) ListProcesses(c context.Context, auth types.BaseGuestAuthentication, pids []int64) ([]types.GuestProcessInfo, error) {
+	return m.MockListProcesses(c, auth, pids)
+}
+
+func TestGuestWait(t *testing.T) {
+	var oldPollInterval = guest.PollInterval
+	defer func() {
+		guest.PollInterval = oldPollInterval
+	}()
+	guest.PollInterval = time.Millisecond
+
+	polls := 0
+	pm := mockProcessLister{}
+	pm.MockListProcesses = func(_ context.Context, _ types.BaseGuestAuthentication, pids []int64) ([]types.GuestProcessInfo, error) {
+		polls++
+		if polls < 2 {
+			return []types.GuestProcessInfo{{Pid: pids[0]}}, nil
+		}
+		now := time.Now()
+		return []types.GuestProcessInfo{{Pid: pids[0], EndTime: &now, ExitCode: 3}}, nil
+	}
+	g := &guestClient{vm: &VM{}}
+	if status, err := g.wait(context.Background(), pm, 42); err != nil || status != 3 {
+		t.Fatalf("Expected the exit code of the process, got: %d, %v", status, err)
+	}
+
+	pm.MockListProcesses = func(_ context.Context, _ types.BaseGuestAuthentication, pids []int64) ([]types.GuestProcessInfo, error) {
+		return []types.GuestProcessInfo{{Pid: pids[0]}}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.wait(ctx, pm, 42); err == nil {
+		t.Fatalf("Expected an error once the context is done")
+	}
+}
+
+func TestGuestOwnSession(t *testing.T) {
+	var oldSetupSession = SetupSession
+	defer func() {
+		SetupSession = oldSetupSession
+	}()
+	var sessionVM *VM
+	SetupSession = func(vm *VM) error {
+		sessionVM = vm
+		return errors.New("no session")
+	}
+
+	vm := &VM{Host: "1.1.1.1", Username: "root", Password: "test", Datacenter: "dc", Name: "test"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	vm.ctx = ctx
+	g, err := vm.GetGuest(guest.Credentials{Username: "user", Password: "pass"})
+	if err != nil {
+		t.Fatalf("Unexpected error getting the guest client: %s", err)
+	}
+	if err := g.Run("true", nil, nil); err == nil {
+		t.Fatalf("Expected the session error")
+	}
+	if sessionVM == nil || sessionVM == vm {
+		t.Fatalf("Expected the guest client to set up a session of its own")
+	}
+	if sessionVM.Host != vm.Host || sessionVM.Datacenter != vm.Datacenter || sessionVM.Name != vm.Name {
+		t.Fatalf("Expected the VM's connection settings, got: %+v", sessionVM)
+	}
+	if vm.ctx != ctx {
+		t.Fatalf("Expected the VM's context to be left alone")
+	}
+}